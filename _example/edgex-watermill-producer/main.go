@@ -48,7 +48,8 @@ func main() {
 			WireFormat:          appSettings["WireFormat"],
 			EncryptionAlgorithm: appSettings["EncryptionAlgorithm"],
 			EncryptionKey:       appSettings["EncryptionKey"],
-		})
+		},
+		service.LoggingClient())
 
 	if err != nil {
 		panic(err)
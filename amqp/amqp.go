@@ -18,106 +18,97 @@ package amqp
 
 import (
 	"context"
-	"github.com/ThreeDotsLabs/watermill"
 	_amqp "github.com/ThreeDotsLabs/watermill-amqp/pkg/amqp"
 	"github.com/ThreeDotsLabs/watermill/message"
 	ewm "github.com/alexcuse/edgex-watermill/v2/core"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
 	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
-	"os"
-	"strings"
 )
 
-func Sender(config ewm.WatermillConfig, proceed bool) (ewm.WatermillSender, error) {
-	pub, err := Publisher(config)
+const backendName = "amqp"
+
+func init() {
+	err := ewm.RegisterBackend(backendName, ewm.Backend{
+		Publisher:  Publisher,
+		Subscriber: Subscriber,
+		Trigger:    Trigger,
+		Client:     Client,
+		Sender:     Sender,
+	})
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func Sender(config ewm.WatermillConfig, proceed bool, lc logger.LoggingClient) (ewm.WatermillSender, error) {
+	pub, err := Publisher(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return ewm.NewWatermillSender(
+	return ewm.NewWatermillSenderWithLogger(
 		pub,
 		proceed,
 		&config,
+		lc,
 	)
 }
 
-func Client(ctx context.Context, config ewm.WatermillConfig) (messaging.MessageClient, error) {
-	pub, err := Publisher(config)
+func Client(ctx context.Context, config ewm.WatermillConfig, lc logger.LoggingClient) (messaging.MessageClient, error) {
+	format := ewm.ResolveWireFormat(config.WireFormat, lc)
+
+	pub, err := Publisher(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	sub, err := Subscriber(config)
+	sub, err := Subscriber(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
-	var fmt ewm.WireFormat
-
-	switch strings.ToLower(config.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
-	}
 
-	return ewm.NewWatermillClient(
+	return ewm.NewWatermillClientWithLogger(
 		ctx,
 		pub,
 		sub,
-		fmt,
+		format,
 		&config,
+		lc,
 	)
 }
 
-func Publisher(config ewm.WatermillConfig) (message.Publisher, error) {
-	return _amqp.NewPublisher(_amqp.NewDurableQueueConfig(config.BrokerUrl), watermill.NewStdLoggerWithOut(os.Stdout, true, false))
+func Publisher(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
+	return _amqp.NewPublisher(_amqp.NewDurableQueueConfig(config.BrokerUrl), ewm.NewBackendLogAdapter(lc, backendName))
 }
 
-func Subscriber(config ewm.WatermillConfig) (message.Subscriber, error) {
-	return _amqp.NewSubscriber(_amqp.NewDurableQueueConfig(config.BrokerUrl), watermill.NewStdLoggerWithOut(os.Stdout, true, false))
+func Subscriber(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error) {
+	return _amqp.NewSubscriber(_amqp.NewDurableQueueConfig(config.BrokerUrl), ewm.NewBackendLogAdapter(lc, backendName))
 }
 
 func Trigger(wc *ewm.WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error) {
-	pub, err := Publisher(wc.WatermillTrigger)
+	format := ewm.ResolveWireFormat(wc.WatermillTrigger.WireFormat, cfg.Logger)
+
+	pub, err := Publisher(wc.WatermillTrigger, cfg.Logger)
 
 	if err != nil {
 		return nil, err
 	}
 
-	sub, err := Subscriber(wc.WatermillTrigger)
+	sub, err := Subscriber(wc.WatermillTrigger, cfg.Logger)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var fmt ewm.WireFormat
-
-	switch strings.ToLower(wc.WatermillTrigger.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
-	}
-
 	return ewm.NewWatermillTrigger(
 		pub,
 		sub,
-		fmt,
+		format,
 		wc,
 		cfg,
 	)
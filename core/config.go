@@ -16,21 +16,61 @@
 
 package core
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
 type WatermillConfigWrapper struct {
 	WatermillTrigger WatermillConfig
 }
 
 type WatermillConfig struct {
-	Type                string
-	BrokerUrl           string
-	ClientId            string
-	SubscribeTopics     string
-	PublishTopic        string
-	WireFormat          string
-	ConsumerGroup       string
-	Optional            map[string]string
-	EncryptionAlgorithm string
-	EncryptionKey       string
+	Type                  string
+	BrokerUrl             string
+	ClientId              string
+	SubscribeTopics       string
+	PublishTopic          string
+	PipelinePublishTopics map[string]string
+	ReplyTopicPrefix      string
+	WireFormat            string
+	SenderWireFormat      string
+	ConsumerGroup         string
+	Optional              map[string]string
+	EncryptionAlgorithm   string
+	EncryptionKey         string
+	DeadLetterTopic       string
+	MaxConcurrency        int
+	ConcurrencyPerTopic   bool
+	OrderingKey           string
+	OrderingWorkers       int
+	PartitionKey          string
+	DrainTimeout          string
+	Retry                 RetryConfig
+	Spool                 SpoolConfig
+	Outbox                OutboxConfig
+	Dedup                 DedupConfig
+	Metrics               MetricsConfig
+	Tracing               TracingConfig
+}
+
+// RetryConfig controls how the trigger handles messages that fail processing.  Retries are
+// disabled unless MaxAttempts is set, leaving failed messages to be nacked (or dead lettered).
+type RetryConfig struct {
+	// MaxAttempts is the total number of times a message will be processed, including the first attempt
+	MaxAttempts int
+	// InitialInterval is the delay before the first retry (eg. "100ms")
+	InitialInterval string
+	// MaxInterval caps the delay between retries (eg. "10s")
+	MaxInterval string
+	// Multiplier scales the delay after each retry
+	Multiplier float64
+	// Jitter randomizes each delay within +/- the given fraction
+	Jitter float64
+	// RetryOn is a comma separated list of error classes to retry ("unmarshal", "pipeline"), defaults to all
+	RetryOn string
 }
 
 func (w *WatermillConfigWrapper) UpdateFromRaw(rawConfig interface{}) bool {
@@ -44,3 +84,139 @@ func (w *WatermillConfigWrapper) UpdateFromRaw(rawConfig interface{}) bool {
 
 	return true
 }
+
+// OptionalValue reads a backend specific setting from Optional, ignoring case as not all
+// configuration providers preserve it
+func (c WatermillConfig) OptionalValue(key string) string {
+	if value, found := c.Optional[key]; found {
+		return value
+	}
+
+	for k, v := range c.Optional {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// OptionalBool reads a boolean setting from Optional, false if not set
+func (c WatermillConfig) OptionalBool(key string) (bool, error) {
+	value := c.OptionalValue(key)
+
+	if value == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+
+	if err != nil {
+		return false, fmt.Errorf("invalid value for %s: %s", key, value)
+	}
+
+	return b, nil
+}
+
+// OptionalInt reads an integer setting from Optional, 0 if not set
+func (c WatermillConfig) OptionalInt(key string) (int, error) {
+	value := c.OptionalValue(key)
+
+	if value == "" {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(value)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %s", key, value)
+	}
+
+	return i, nil
+}
+
+// OptionalDuration reads a duration setting (eg. "30s") from Optional, 0 if not set
+func (c WatermillConfig) OptionalDuration(key string) (time.Duration, error) {
+	value := c.OptionalValue(key)
+
+	if value == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(value)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for %s: %s", key, err.Error())
+	}
+
+	return d, nil
+}
+
+// SpoolConfig enables store and forward of messages that fail to publish, eg. while the broker
+// is unreachable.  Spooling is disabled unless Path is set.
+type SpoolConfig struct {
+	// Path is the location of the spool database file
+	Path string
+	// MaxMessages bounds the number of messages held in the spool (default 10000)
+	MaxMessages int
+	// RetryInterval is how often spooled messages are replayed (eg. "5s")
+	RetryInterval string
+	// MaxAttempts is how many times the oldest spooled message is replayed before it is moved
+	// aside to a dead letter bucket in the spool file, unblocking the messages behind it.
+	// Replays fail while the broker is down too, so leave unset (retry forever) unless
+	// outages are expected to be shorter than MaxAttempts * RetryInterval.
+	MaxAttempts int
+}
+
+// OutboxConfig enables the transactional outbox for trigger output.  Published messages are
+// committed to a local database and relayed to the broker in the background.  Each received
+// message is processed in a single outbox transaction, so pipelines run one message at a time
+// regardless of MaxConcurrency.  The outbox is disabled unless Path is set.
+type OutboxConfig struct {
+	// Path is the location of the outbox database file, also used to find it with LookupOutbox
+	Path string
+	// RelayInterval is how often the relay retries undelivered messages (eg. "1s")
+	RelayInterval string
+}
+
+// DedupConfig enables skipping of messages redelivered after they were processed successfully.
+// Deduplication is disabled unless Key is set.
+type DedupConfig struct {
+	// Key identifies duplicate messages, either "uuid" or "correlationid"
+	Key string
+	// TTL is how long processed keys are remembered (eg. "10m")
+	TTL string
+	// Store is the name of the store used to remember keys, "memory" (default) or "file"
+	Store string
+	// Path is the location of the database file used by the file store
+	Path string
+	// MaxEntries bounds the number of keys held by the memory store (default 10000)
+	MaxEntries int
+}
+
+// MetricsConfig enables prometheus metrics.  Metrics are served on the application service's
+// webserver at MetricsRoute, and optionally from a standalone endpoint.
+type MetricsConfig struct {
+	Enabled bool
+	// ListenAddress starts a standalone HTTP server exposing /metrics (eg. ":9090")
+	ListenAddress string
+	// PublishTopicLabels labels publish metrics with the topic published to.  Templated and
+	// reply topics can produce a new series per message, so publish metrics are only labelled
+	// by backend unless this is set.
+	PublishTopicLabels bool
+}
+
+// TracingConfig controls export of the spans started by the trigger.  Trace context carried by
+// incoming messages is propagated to output even when no exporter is configured.
+type TracingConfig struct {
+	// Exporter is "none" (default), "stdout" or "otlp"
+	Exporter string
+	// Endpoint is the host:port of the OTLP/HTTP collector
+	Endpoint string
+	// Insecure disables TLS for the OTLP exporter
+	Insecure bool
+	// ServiceName identifies this service in exported spans (default "edgex-watermill")
+	ServiceName string
+	// SampleRatio is the fraction of new traces sampled (default 1)
+	SampleRatio float64
+}
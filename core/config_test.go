@@ -0,0 +1,61 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestOptionalValue(t *testing.T) {
+	config := WatermillConfig{Optional: map[string]string{"durablename": "lower", "QueueGroup": "exact"}}
+
+	require.Equal(t, "lower", config.OptionalValue("DurableName"), "should ignore case")
+	require.Equal(t, "exact", config.OptionalValue("QueueGroup"))
+	require.Empty(t, config.OptionalValue("Missing"))
+	require.Empty(t, WatermillConfig{}.OptionalValue("Missing"))
+}
+
+func TestOptional_Typed(t *testing.T) {
+	config := WatermillConfig{Optional: map[string]string{"Bool": "true", "Int": "42", "Duration": "30s", "Bad": "bad"}}
+
+	b, err := config.OptionalBool("Bool")
+	require.NoError(t, err)
+	require.True(t, b)
+
+	i, err := config.OptionalInt("Int")
+	require.NoError(t, err)
+	require.Equal(t, 42, i)
+
+	d, err := config.OptionalDuration("Duration")
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, d)
+
+	_, err = config.OptionalBool("Bad")
+	require.Error(t, err)
+
+	_, err = config.OptionalInt("Bad")
+	require.Error(t, err)
+
+	_, err = config.OptionalDuration("Bad")
+	require.Error(t, err)
+
+	b, err = config.OptionalBool("Missing")
+	require.NoError(t, err)
+	require.False(t, b, "should default when not set")
+}
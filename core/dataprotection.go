@@ -25,7 +25,8 @@ import (
 	"strings"
 )
 
-type binaryModifier func([]byte) ([]byte, error)
+// BinaryModifier transforms a payload, eg. to apply or remove encryption
+type BinaryModifier func([]byte) ([]byte, error)
 
 func noopModifier(b []byte) ([]byte, error) {
 	return b, nil
@@ -0,0 +1,78 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/hashicorp/go-multierror"
+	"strings"
+	"time"
+)
+
+// Metadata keys added to messages published to the dead letter topic.  Error and topic keys
+// match those used by watermill's poison queue middleware.
+const (
+	DeadLetterErrorKey    = middleware.ReasonForPoisonedKey
+	DeadLetterTopicKey    = middleware.PoisonedTopicKey
+	DeadLetterPipelineKey = "pipeline_poisoned"
+	DeadLetterTimeKey     = "time_poisoned"
+)
+
+// pipelineError associates an output failure with the pipeline that produced it
+type pipelineError struct {
+	pipelineId string
+	err        error
+}
+
+func (pe *pipelineError) Error() string {
+	return pe.err.Error()
+}
+
+func (pe *pipelineError) Unwrap() error {
+	return pe.err
+}
+
+// failedPipelines collects the ids of pipelines known to have failed.  Errors raised by the
+// SDK while running a pipeline's functions do not identify the pipeline so may not be included.
+func failedPipelines(err error) []string {
+	var ids []string
+
+	switch e := err.(type) {
+	case *pipelineError:
+		ids = append(ids, e.pipelineId)
+	case *multierror.Error:
+		for _, inner := range e.Errors {
+			ids = append(ids, failedPipelines(inner)...)
+		}
+	case *processingError:
+		ids = append(ids, failedPipelines(e.err)...)
+	}
+
+	return ids
+}
+
+func (t *watermillTrigger) deadLetter(conn *connectionSnapshot, msg *message.Message, receiveTopic string, cause error) error {
+	dead := msg.Copy()
+
+	dead.Metadata.Set(DeadLetterErrorKey, cause.Error())
+	dead.Metadata.Set(DeadLetterTopicKey, receiveTopic)
+	dead.Metadata.Set(DeadLetterPipelineKey, strings.Join(failedPipelines(cause), ","))
+	dead.Metadata.Set(DeadLetterTimeKey, time.Now().UTC().Format(time.RFC3339Nano))
+
+	return conn.pub.Publish(t.config().DeadLetterTopic, dead)
+}
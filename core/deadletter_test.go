@@ -0,0 +1,56 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/google/uuid"
+	"github.com/hashicorp/go-multierror"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestFailedPipelines(t *testing.T) {
+	var err error
+
+	err = multierror.Append(err, &pipelineError{pipelineId: "a", err: errors.New("")})
+	err = multierror.Append(err, errors.New("unknown pipeline"))
+	err = multierror.Append(err, &pipelineError{pipelineId: "b", err: errors.New("")})
+
+	require.Equal(t, []string{"a", "b"}, failedPipelines(&processingError{class: ErrorClassPipeline, err: err}))
+}
+
+func TestOutput_PublishError_IdentifiesPipeline(t *testing.T) {
+	ctx := pkg.NewAppFuncContextForTest(uuid.NewString(), logger.MockLogger{})
+	ctx.SetResponseData([]byte(uuid.NewString()))
+
+	marshaler := mockMarshaler{}
+	marshaler.On("Execute", mock.Anything, mock.Anything).Return(nil, errors.New(""))
+
+	sut := watermillTrigger{pub: &mockPublisher{}, marshaler: marshaler.Execute, watermillConfig: &WatermillConfigWrapper{}}
+
+	pipelineId := uuid.NewString()
+
+	err := sut.output(ctx, &interfaces.FunctionPipeline{Id: pipelineId})
+
+	require.Error(t, err)
+	require.Equal(t, []string{pipelineId}, failedPipelines(err))
+}
@@ -0,0 +1,367 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"container/list"
+	"encoding/binary"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DedupKeyUUID          = "uuid"
+	DedupKeyCorrelationID = "correlationid"
+
+	MemoryDedupStoreName = "memory"
+	FileDedupStoreName   = "file"
+)
+
+const (
+	defaultDedupTTL        = 10 * time.Minute
+	defaultDedupMaxEntries = 10000
+
+	dedupBucket = "dedup"
+	dedupPrune  = 1000
+)
+
+// DedupStore remembers the keys of messages that have been processed so redeliveries can be skipped
+type DedupStore interface {
+	// Seen reports whether key was marked and has not yet expired
+	Seen(key string) (bool, error)
+	// Mark records key as processed until ttl has elapsed
+	Mark(key string, ttl time.Duration) error
+	Close() error
+}
+
+// DedupStoreFactory creates a store from the trigger's deduplication settings
+type DedupStoreFactory func(config DedupConfig) (DedupStore, error)
+
+var (
+	dedupStoreMutex sync.RWMutex
+	dedupStores     = map[string]DedupStoreFactory{
+		MemoryDedupStoreName: newMemoryDedupStore,
+		FileDedupStoreName:   newFileDedupStore,
+	}
+)
+
+// RegisterDedupStore makes a store available under the name used for DedupConfig.Store
+func RegisterDedupStore(name string, factory DedupStoreFactory) error {
+	key := strings.ToLower(strings.TrimSpace(name))
+
+	if key == "" {
+		return fmt.Errorf("dedup store name must be specified")
+	}
+
+	if factory == nil {
+		return fmt.Errorf("dedup store factory must be specified for '%s'", key)
+	}
+
+	dedupStoreMutex.Lock()
+	defer dedupStoreMutex.Unlock()
+
+	if _, exists := dedupStores[key]; exists {
+		return fmt.Errorf("dedup store already registered: '%s'", key)
+	}
+
+	dedupStores[key] = factory
+
+	return nil
+}
+
+func lookupDedupStore(name string) (DedupStoreFactory, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+
+	if key == "" {
+		key = MemoryDedupStoreName
+	}
+
+	dedupStoreMutex.RLock()
+	defer dedupStoreMutex.RUnlock()
+
+	factory, found := dedupStores[key]
+
+	if !found {
+		names := make([]string, 0, len(dedupStores))
+		for n := range dedupStores {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		return nil, fmt.Errorf("invalid dedup store specified: '%s' (registered: %s)", name, strings.Join(names, ", "))
+	}
+
+	return factory, nil
+}
+
+// deduplicator skips messages whose key has already been processed within the ttl
+type deduplicator struct {
+	store   DedupStore
+	key     string
+	ttl     time.Duration
+	mutex   sync.Mutex
+	skipped uint64
+}
+
+func newDeduplicator(config DedupConfig) (*deduplicator, error) {
+	d, err := parseDeduplicator(config)
+
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	factory, err := lookupDedupStore(config.Store)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if d.store, err = factory(config); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// parseDeduplicator validates config, returning a deduplicator without a store
+func parseDeduplicator(config DedupConfig) (*deduplicator, error) {
+	if config.Key == "" {
+		return nil, nil
+	}
+
+	d := &deduplicator{
+		ttl: defaultDedupTTL,
+	}
+
+	switch k := strings.ToLower(strings.TrimSpace(config.Key)); k {
+	case DedupKeyUUID, DedupKeyCorrelationID:
+		d.key = k
+	default:
+		return nil, fmt.Errorf("invalid dedup key specified: %s", config.Key)
+	}
+
+	if config.TTL != "" {
+		var err error
+
+		if d.ttl, err = time.ParseDuration(config.TTL); err != nil {
+			return nil, fmt.Errorf("invalid dedup ttl: %s", err.Error())
+		}
+	}
+
+	return d, nil
+}
+
+// messageKey returns the key a message is deduplicated on, or an empty key if it has none
+func (d *deduplicator) messageKey(msg *message.Message) string {
+	if d.key == DedupKeyCorrelationID {
+		return msg.Metadata.Get(middleware.CorrelationIDMetadataKey)
+	}
+	return msg.UUID
+}
+
+// skip returns the number of duplicates skipped so far
+func (d *deduplicator) skip() uint64 {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.skipped++
+
+	return d.skipped
+}
+
+type memoryDedupEntry struct {
+	key     string
+	expires time.Time
+}
+
+// memoryDedupStore is an LRU of processed keys, evicting the least recently marked once full
+type memoryDedupStore struct {
+	mutex   sync.Mutex
+	max     int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newMemoryDedupStore(config DedupConfig) (DedupStore, error) {
+	max := config.MaxEntries
+
+	if max <= 0 {
+		max = defaultDedupMaxEntries
+	}
+
+	return &memoryDedupStore{
+		max:     max,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}, nil
+}
+
+func (s *memoryDedupStore) Seen(key string) (bool, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	e, found := s.entries[key]
+
+	if !found {
+		return false, nil
+	}
+
+	if time.Now().After(e.Value.(*memoryDedupEntry).expires) {
+		s.order.Remove(e)
+		delete(s.entries, key)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+func (s *memoryDedupStore) Mark(key string, ttl time.Duration) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	expires := time.Now().Add(ttl)
+
+	if e, found := s.entries[key]; found {
+		e.Value.(*memoryDedupEntry).expires = expires
+		s.order.MoveToFront(e)
+		return nil
+	}
+
+	s.entries[key] = s.order.PushFront(&memoryDedupEntry{key: key, expires: expires})
+
+	for s.order.Len() > s.max {
+		oldest := s.order.Back()
+		s.order.Remove(oldest)
+		delete(s.entries, oldest.Value.(*memoryDedupEntry).key)
+	}
+
+	return nil
+}
+
+func (s *memoryDedupStore) Close() error {
+	return nil
+}
+
+// fileDedupStore keeps processed keys in a bolt database so they survive restarts.  Expired
+// keys are pruned periodically as new keys are marked.
+type fileDedupStore struct {
+	db    *bolt.DB
+	mutex sync.Mutex
+	marks int
+}
+
+func newFileDedupStore(config DedupConfig) (DedupStore, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("dedup path must be specified for the file store")
+	}
+
+	db, err := bolt.Open(config.Path, 0600, &bolt.Options{Timeout: time.Second})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileDedupStore{db: db}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(dedupBucket)); err != nil {
+			return err
+		}
+		return s.prune(tx)
+	})
+
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *fileDedupStore) Seen(key string) (bool, error) {
+	seen := false
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket([]byte(dedupBucket)).Get([]byte(key))
+
+		if len(v) == 8 {
+			seen = time.Now().UnixNano() < int64(binary.BigEndian.Uint64(v))
+		}
+
+		return nil
+	})
+
+	return seen, err
+}
+
+func (s *fileDedupStore) Mark(key string, ttl time.Duration) error {
+	s.mutex.Lock()
+	s.marks++
+	prune := s.marks%dedupPrune == 0
+	s.mutex.Unlock()
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		expires := make([]byte, 8)
+		binary.BigEndian.PutUint64(expires, uint64(time.Now().Add(ttl).UnixNano()))
+
+		if err := tx.Bucket([]byte(dedupBucket)).Put([]byte(key), expires); err != nil {
+			return err
+		}
+
+		if prune {
+			return s.prune(tx)
+		}
+
+		return nil
+	})
+}
+
+func (s *fileDedupStore) prune(tx *bolt.Tx) error {
+	now := time.Now().UnixNano()
+	b := tx.Bucket([]byte(dedupBucket))
+
+	var expired [][]byte
+
+	err := b.ForEach(func(k, v []byte) error {
+		if len(v) != 8 || now >= int64(binary.BigEndian.Uint64(v)) {
+			expired = append(expired, append([]byte{}, k...))
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	for _, k := range expired {
+		if err = b.Delete(k); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *fileDedupStore) Close() error {
+	return s.db.Close()
+}
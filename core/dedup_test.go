@@ -0,0 +1,193 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryDedupStore(t *testing.T) {
+	sut, err := newMemoryDedupStore(DedupConfig{})
+
+	require.NoError(t, err)
+
+	key := uuid.NewString()
+
+	seen, err := sut.Seen(key)
+
+	require.NoError(t, err)
+	require.False(t, seen)
+
+	require.NoError(t, sut.Mark(key, time.Minute))
+
+	seen, err = sut.Seen(key)
+
+	require.NoError(t, err)
+	require.True(t, seen)
+}
+
+func TestMemoryDedupStore_Expired(t *testing.T) {
+	sut, _ := newMemoryDedupStore(DedupConfig{})
+
+	key := uuid.NewString()
+
+	require.NoError(t, sut.Mark(key, -time.Second))
+
+	seen, err := sut.Seen(key)
+
+	require.NoError(t, err)
+	require.False(t, seen)
+}
+
+func TestMemoryDedupStore_Evicts(t *testing.T) {
+	sut, _ := newMemoryDedupStore(DedupConfig{MaxEntries: 2})
+
+	first, second, third := uuid.NewString(), uuid.NewString(), uuid.NewString()
+
+	require.NoError(t, sut.Mark(first, time.Minute))
+	require.NoError(t, sut.Mark(second, time.Minute))
+	require.NoError(t, sut.Mark(third, time.Minute))
+
+	seen, _ := sut.Seen(first)
+	require.False(t, seen, "least recently marked key should be evicted")
+
+	seen, _ = sut.Seen(third)
+	require.True(t, seen)
+}
+
+func TestFileDedupStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.db")
+
+	sut, err := newFileDedupStore(DedupConfig{Path: path})
+
+	require.NoError(t, err)
+
+	key, expired := uuid.NewString(), uuid.NewString()
+
+	require.NoError(t, sut.Mark(key, time.Minute))
+	require.NoError(t, sut.Mark(expired, -time.Second))
+	require.NoError(t, sut.Close())
+
+	sut, err = newFileDedupStore(DedupConfig{Path: path})
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	seen, err := sut.Seen(key)
+
+	require.NoError(t, err)
+	require.True(t, seen, "keys should survive a restart")
+
+	seen, err = sut.Seen(expired)
+
+	require.NoError(t, err)
+	require.False(t, seen)
+}
+
+func TestFileDedupStore_NoPath(t *testing.T) {
+	_, err := newFileDedupStore(DedupConfig{})
+
+	require.Error(t, err)
+}
+
+func TestNewDeduplicator(t *testing.T) {
+	tests := []struct {
+		name      string
+		config    DedupConfig
+		expectNil bool
+		expectErr bool
+	}{
+		{"Disabled", DedupConfig{}, true, false},
+		{"UUID", DedupConfig{Key: "UUID"}, false, false},
+		{"CorrelationID", DedupConfig{Key: DedupKeyCorrelationID, TTL: "1m"}, false, false},
+		{"InvalidKey", DedupConfig{Key: "other"}, true, true},
+		{"InvalidTTL", DedupConfig{Key: DedupKeyUUID, TTL: "soon"}, true, true},
+		{"InvalidStore", DedupConfig{Key: DedupKeyUUID, Store: uuid.NewString()}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := newDeduplicator(tt.config)
+
+			require.Equal(t, tt.expectErr, err != nil)
+			require.Equal(t, tt.expectNil, d == nil)
+		})
+	}
+}
+
+func TestRegisterDedupStore(t *testing.T) {
+	name := uuid.NewString()
+	store, _ := newMemoryDedupStore(DedupConfig{})
+
+	require.NoError(t, RegisterDedupStore(name, func(config DedupConfig) (DedupStore, error) {
+		return store, nil
+	}))
+
+	require.Error(t, RegisterDedupStore(name, newMemoryDedupStore), "should not register twice")
+
+	d, err := newDeduplicator(DedupConfig{Key: DedupKeyUUID, Store: name})
+
+	require.NoError(t, err)
+	require.Same(t, store, d.store)
+}
+
+func TestInput_Dedup(t *testing.T) {
+	sut, calls := newInputTestTrigger(t, nil)
+
+	sut.dedup, _ = newDeduplicator(DedupConfig{Key: DedupKeyCorrelationID})
+
+	correlationID := uuid.NewString()
+
+	first := message.NewMessage(uuid.NewString(), []byte("{}"))
+	first.Metadata.Set(middleware.CorrelationIDMetadataKey, correlationID)
+
+	redelivered := message.NewMessage(uuid.NewString(), []byte("{}"))
+	redelivered.Metadata.Set(middleware.CorrelationIDMetadataKey, correlationID)
+
+	sut.input(first, uuid.NewString())
+	sut.input(redelivered, uuid.NewString())
+
+	require.Equal(t, 1, *calls)
+	requireAcked(t, first)
+	requireAcked(t, redelivered)
+	require.Equal(t, uint64(1), sut.dedup.skipped)
+}
+
+func TestInput_Dedup_NotMarkedOnFailure(t *testing.T) {
+	sut, calls := newInputTestTrigger(t, nil, errors.New("pipeline"))
+
+	sut.dedup, _ = newDeduplicator(DedupConfig{Key: DedupKeyUUID})
+
+	id := uuid.NewString()
+
+	failed := message.NewMessage(id, []byte("{}"))
+	redelivered := message.NewMessage(id, []byte("{}"))
+
+	sut.input(failed, uuid.NewString())
+	sut.input(redelivered, uuid.NewString())
+
+	require.Equal(t, 2, *calls)
+	requireNacked(t, failed)
+	requireAcked(t, redelivered)
+}
@@ -0,0 +1,233 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	bolt "go.etcd.io/bbolt"
+	"sync"
+	"time"
+)
+
+var ErrQueueFull = errors.New("queue is full")
+
+// queuedMessage is the persisted form of a message waiting to be published
+type queuedMessage struct {
+	Topic    string
+	UUID     string
+	Metadata map[string]string
+	Payload  []byte
+}
+
+// diskQueue is a bounded FIFO queue of messages persisted to a local bolt database.  The number
+// of queued messages is counted once at open and kept up to date as transactions commit, so
+// depth checks don't have to walk the bucket.
+type diskQueue struct {
+	db     *bolt.DB
+	bucket []byte
+	max    int
+	mutex  sync.Mutex
+	count  int
+}
+
+func openDiskQueue(path string, bucket string, max int) (*diskQueue, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+
+	if err != nil {
+		return nil, err
+	}
+
+	q := &diskQueue{
+		db:     db,
+		bucket: []byte(bucket),
+		max:    max,
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists(q.bucket)
+
+		if err != nil {
+			return err
+		}
+
+		q.count = b.Stats().KeyN
+
+		return nil
+	})
+
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// push appends messages to the queue in a single transaction
+func (q *diskQueue) push(topic string, messages ...*message.Message) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return q.pushTx(tx, topic, messages...)
+	})
+}
+
+func (q *diskQueue) pushTx(tx *bolt.Tx, topic string, messages ...*message.Message) error {
+	b := tx.Bucket(q.bucket)
+
+	if q.max > 0 && q.depth()+len(messages) > q.max {
+		return ErrQueueFull
+	}
+
+	for _, msg := range messages {
+		seq, err := b.NextSequence()
+
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(queuedMessage{
+			Topic:    topic,
+			UUID:     msg.UUID,
+			Metadata: msg.Metadata,
+			Payload:  msg.Payload,
+		})
+
+		if err != nil {
+			return err
+		}
+
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+
+		if err = b.Put(key, value); err != nil {
+			return err
+		}
+	}
+
+	tx.OnCommit(func() {
+		q.adjust(len(messages))
+	})
+
+	return nil
+}
+
+// peek returns the oldest message in the queue along with its key, or a nil key if empty
+func (q *diskQueue) peek() ([]byte, string, *message.Message, error) {
+	var key []byte
+	var qm queuedMessage
+
+	err := q.db.View(func(tx *bolt.Tx) error {
+		k, v := tx.Bucket(q.bucket).Cursor().First()
+
+		if k == nil {
+			return nil
+		}
+
+		key = append([]byte{}, k...)
+
+		return json.Unmarshal(v, &qm)
+	})
+
+	if err != nil || key == nil {
+		return nil, "", nil, err
+	}
+
+	msg := message.NewMessage(qm.UUID, qm.Payload)
+
+	for k, v := range qm.Metadata {
+		msg.Metadata.Set(k, v)
+	}
+
+	return key, qm.Topic, msg, nil
+}
+
+func (q *diskQueue) remove(key []byte) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		return q.removeTx(tx, key)
+	})
+}
+
+func (q *diskQueue) removeTx(tx *bolt.Tx, key []byte) error {
+	b := tx.Bucket(q.bucket)
+
+	if b.Get(key) == nil {
+		return nil
+	}
+
+	if err := b.Delete(key); err != nil {
+		return err
+	}
+
+	tx.OnCommit(func() {
+		q.adjust(-1)
+	})
+
+	return nil
+}
+
+// move transfers the message stored under key to another bucket, eg. to set it aside after
+// it repeatedly fails to publish
+func (q *diskQueue) move(key []byte, bucket string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		value := tx.Bucket(q.bucket).Get(key)
+
+		if value == nil {
+			return nil
+		}
+
+		target, err := tx.CreateBucketIfNotExists([]byte(bucket))
+
+		if err != nil {
+			return err
+		}
+
+		seq, err := target.NextSequence()
+
+		if err != nil {
+			return err
+		}
+
+		moved := make([]byte, 8)
+		binary.BigEndian.PutUint64(moved, seq)
+
+		if err = target.Put(moved, append([]byte{}, value...)); err != nil {
+			return err
+		}
+
+		return q.removeTx(tx, key)
+	})
+}
+
+func (q *diskQueue) adjust(delta int) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	q.count += delta
+}
+
+func (q *diskQueue) depth() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.count
+}
+
+func (q *diskQueue) close() error {
+	return q.db.Close()
+}
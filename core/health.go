@@ -0,0 +1,295 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"encoding/json"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// HealthRoute is where Register exposes connection health on the application service's webserver
+const HealthRoute = "/api/v2/watermill/health"
+
+// HealthStatus is a snapshot of the health of a trigger or client's broker connection
+type HealthStatus struct {
+	Backend     string    `json:"backend"`
+	Connected   bool      `json:"connected"`
+	LastPublish time.Time `json:"lastPublish,omitempty"`
+	LastReceive time.Time `json:"lastReceive,omitempty"`
+	LastError   string    `json:"lastError,omitempty"`
+	Reconnects  int       `json:"reconnects"`
+}
+
+// HealthReporter is implemented by triggers and clients that track the health of their connection
+type HealthReporter interface {
+	Health() HealthStatus
+}
+
+// HealthCallback is notified whenever a connection becomes connected or disconnected
+type HealthCallback func(name string, status HealthStatus)
+
+var (
+	healthMutex     sync.RWMutex
+	healthTrackers  = make(map[string]*healthTracker)
+	healthCallbacks []HealthCallback
+)
+
+// OnHealthChange registers a callback for connection state changes, eg. to feed a health aggregator
+func OnHealthChange(callback HealthCallback) {
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	healthCallbacks = append(healthCallbacks, callback)
+}
+
+// Health returns the status of every open trigger and client connection by name
+func Health() map[string]HealthStatus {
+	healthMutex.RLock()
+	defer healthMutex.RUnlock()
+
+	statuses := make(map[string]HealthStatus, len(healthTrackers))
+
+	for name, tracker := range healthTrackers {
+		statuses[name] = tracker.Health()
+	}
+
+	return statuses
+}
+
+// HealthHandler serves connection health as JSON, responding 503 if any connection is down
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		statuses := Health()
+
+		healthy := true
+
+		for _, status := range statuses {
+			healthy = healthy && status.Connected
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if !healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		_ = json.NewEncoder(w).Encode(struct {
+			Healthy     bool                    `json:"healthy"`
+			Connections map[string]HealthStatus `json:"connections"`
+		}{healthy, statuses})
+	})
+}
+
+// healthTracker records connection events for a single trigger or client
+type healthTracker struct {
+	name   string
+	kind   string
+	id     string
+	mutex  sync.Mutex
+	status HealthStatus
+}
+
+func newHealthTracker(backend string) *healthTracker {
+	return &healthTracker{status: HealthStatus{Backend: backend}}
+}
+
+// register starts reporting the tracker under a name derived from kind, backend and id
+func (ht *healthTracker) register(kind string, id string) {
+	if ht == nil {
+		return
+	}
+
+	ht.mutex.Lock()
+	ht.kind, ht.id = kind, id
+	name := kind + ":" + ht.status.Backend
+	ht.mutex.Unlock()
+
+	if id != "" {
+		name = name + ":" + id
+	}
+
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	if healthTrackers[ht.name] == ht {
+		return
+	}
+
+	// a second connection with the same identity is told apart by a sequence number
+	unique := name
+	for i := 2; healthTrackers[unique] != nil; i++ {
+		unique = name + ":" + strconv.Itoa(i)
+	}
+
+	ht.mutex.Lock()
+	ht.name = unique
+	ht.mutex.Unlock()
+
+	healthTrackers[unique] = ht
+}
+
+// setBackend reports the tracker under a new backend once a trigger is reconnected
+func (ht *healthTracker) setBackend(backend string) {
+	if ht == nil {
+		return
+	}
+
+	ht.mutex.Lock()
+	changed := ht.status.Backend != backend
+	ht.status.Backend = backend
+	kind, id := ht.kind, ht.id
+	ht.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	healthMutex.Lock()
+	registered := healthTrackers[ht.name] == ht
+	if registered {
+		delete(healthTrackers, ht.name)
+	}
+	healthMutex.Unlock()
+
+	if registered {
+		ht.register(kind, id)
+	}
+}
+
+func (ht *healthTracker) Health() HealthStatus {
+	if ht == nil {
+		return HealthStatus{}
+	}
+
+	ht.mutex.Lock()
+	defer ht.mutex.Unlock()
+
+	return ht.status
+}
+
+// update applies fn to the status, notifying callbacks if the connected state changed
+func (ht *healthTracker) update(fn func(status *HealthStatus)) {
+	if ht == nil {
+		return
+	}
+
+	ht.mutex.Lock()
+
+	was := ht.status.Connected
+
+	fn(&ht.status)
+
+	changed := was != ht.status.Connected
+	name, status := ht.name, ht.status
+
+	ht.mutex.Unlock()
+
+	if changed {
+		healthMutex.RLock()
+		callbacks := append([]HealthCallback{}, healthCallbacks...)
+		healthMutex.RUnlock()
+
+		for _, callback := range callbacks {
+			callback(name, status)
+		}
+	}
+}
+
+func (ht *healthTracker) connected() {
+	ht.update(func(status *HealthStatus) {
+		status.Connected = true
+	})
+}
+
+func (ht *healthTracker) disconnected(err error) {
+	ht.update(func(status *HealthStatus) {
+		status.Connected = false
+
+		if err != nil {
+			status.LastError = err.Error()
+		}
+	})
+}
+
+func (ht *healthTracker) reconnected() {
+	ht.update(func(status *HealthStatus) {
+		status.Reconnects++
+	})
+}
+
+func (ht *healthTracker) received() {
+	ht.update(func(status *HealthStatus) {
+		status.LastReceive = time.Now().UTC()
+	})
+}
+
+func (ht *healthTracker) published(err error) {
+	ht.update(func(status *HealthStatus) {
+		if err != nil {
+			status.Connected = false
+			status.LastError = err.Error()
+			return
+		}
+
+		status.Connected = true
+		status.LastPublish = time.Now().UTC()
+	})
+}
+
+// close stops reporting the tracker's connection
+func (ht *healthTracker) close() {
+	if ht == nil {
+		return
+	}
+
+	healthMutex.Lock()
+	defer healthMutex.Unlock()
+
+	if healthTrackers[ht.name] == ht {
+		delete(healthTrackers, ht.name)
+	}
+}
+
+// healthPublisher records the outcome of each publish against a tracker
+type healthPublisher struct {
+	message.Publisher
+	health *healthTracker
+}
+
+func (hp *healthPublisher) Publish(topic string, messages ...*message.Message) error {
+	err := hp.Publisher.Publish(topic, messages...)
+
+	hp.health.published(err)
+
+	return err
+}
+
+func (hp *healthPublisher) SpoolDepth() int {
+	return spoolDepth(hp.Publisher)
+}
+
+func trackPublisher(pub message.Publisher, health *healthTracker) message.Publisher {
+	if pub == nil || health == nil {
+		return pub
+	}
+
+	return &healthPublisher{Publisher: pub, health: health}
+}
@@ -0,0 +1,171 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealth_Trigger(t *testing.T) {
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	backend := uuid.NewString()
+
+	sut, received := newReconfigureTestTrigger(t, pubSub, WatermillConfig{Type: backend, SubscribeTopics: "in"})
+
+	status, found := Health()["trigger:"+backend]
+
+	require.True(t, found, "trigger should be registered")
+	require.True(t, status.Connected)
+	require.Equal(t, backend, status.Backend)
+	require.True(t, status.LastReceive.IsZero())
+
+	require.NoError(t, pubSub.Publish("in", message.NewMessage(uuid.NewString(), []byte("{}"))))
+	requireReceived(t, received, "{}")
+
+	require.False(t, sut.Health().LastReceive.IsZero(), "receive should be recorded")
+}
+
+func TestHealth_TriggerReconnect(t *testing.T) {
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	sut, _ := newReconfigureTestTrigger(t, pubSub, WatermillConfig{SubscribeTopics: "in"})
+
+	sut.swapConnection(&triggerConnection{pub: pubSub, sub: pubSub, format: &RawWireFormat{}}, WatermillConfig{SubscribeTopics: "in"})
+
+	require.Equal(t, 1, sut.Health().Reconnects)
+}
+
+func TestHealth_Publish(t *testing.T) {
+	pub := &flakyPublisher{}
+	ht := newHealthTracker("test")
+
+	sut := trackPublisher(pub, ht)
+
+	require.NoError(t, sut.Publish("topic", message.NewMessage(uuid.NewString(), nil)))
+
+	status := ht.Health()
+
+	require.True(t, status.Connected)
+	require.False(t, status.LastPublish.IsZero())
+
+	pub.setDown(true)
+
+	require.Error(t, sut.Publish("topic", message.NewMessage(uuid.NewString(), nil)))
+
+	status = ht.Health()
+
+	require.False(t, status.Connected)
+	require.NotEmpty(t, status.LastError)
+}
+
+func TestHealth_Client(t *testing.T) {
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	clientId := uuid.NewString()
+
+	sut, err := NewWatermillClient(context.Background(), pubSub, pubSub, &RawWireFormat{}, &WatermillConfig{Type: "test", ClientId: clientId})
+
+	require.NoError(t, err)
+
+	name := "client:test:" + clientId
+
+	_, found := Health()[name]
+	require.False(t, found, "client should not be registered until connected")
+
+	require.NoError(t, sut.Connect())
+
+	status, found := Health()[name]
+	require.True(t, found, "client should be registered once connected")
+	require.True(t, status.Connected)
+
+	_ = sut.Disconnect()
+
+	_, found = Health()[name]
+	require.False(t, found, "client should be removed once disconnected")
+}
+
+func TestOnHealthChange(t *testing.T) {
+	ht := newHealthTracker("test")
+	ht.register("test", uuid.NewString())
+	defer ht.close()
+
+	var changes []HealthStatus
+
+	OnHealthChange(func(name string, status HealthStatus) {
+		if name == ht.name {
+			changes = append(changes, status)
+		}
+	})
+
+	ht.connected()
+	ht.received()
+	ht.connected()
+	ht.disconnected(errors.New("gone"))
+
+	require.Len(t, changes, 2, "only connection changes should be reported")
+	require.True(t, changes[0].Connected)
+	require.False(t, changes[1].Connected)
+	require.Equal(t, "gone", changes[1].LastError)
+}
+
+func TestHealthHandler(t *testing.T) {
+	ht := newHealthTracker("test")
+	ht.register("test", uuid.NewString())
+	defer ht.close()
+
+	serve := func() (int, map[string]HealthStatus) {
+		recorder := httptest.NewRecorder()
+
+		HealthHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, HealthRoute, nil))
+
+		var body struct {
+			Healthy     bool
+			Connections map[string]HealthStatus
+		}
+
+		require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+		require.Equal(t, recorder.Code == http.StatusOK, body.Healthy)
+
+		return recorder.Code, body.Connections
+	}
+
+	code, connections := serve()
+
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Contains(t, connections, ht.name)
+
+	ht.connected()
+	ht.received()
+
+	code, connections = serve()
+
+	require.Equal(t, http.StatusOK, code)
+	require.True(t, connections[ht.name].Connected)
+	require.WithinDuration(t, time.Now(), connections[ht.name].LastReceive, time.Second)
+}
@@ -0,0 +1,129 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// inflightTracker counts work that must finish before connections are closed.  Once draining
+// no new work is accepted.
+type inflightTracker struct {
+	mutex    sync.Mutex
+	count    int
+	draining bool
+	idle     chan struct{}
+	resumed  chan struct{}
+}
+
+// begin registers new work, returning false if the tracker is draining
+func (it *inflightTracker) begin() bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	if it.draining {
+		return false
+	}
+
+	it.count++
+
+	return true
+}
+
+func (it *inflightTracker) end() {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	it.count--
+
+	if it.count == 0 && it.idle != nil {
+		close(it.idle)
+		it.idle = nil
+	}
+}
+
+// drain stops accepting new work and waits up to timeout for in flight work to complete,
+// returning the amount of work abandoned
+func (it *inflightTracker) drain(timeout time.Duration) int {
+	it.mutex.Lock()
+
+	it.draining = true
+
+	if it.resumed == nil {
+		it.resumed = make(chan struct{})
+	}
+
+	if it.count == 0 {
+		it.mutex.Unlock()
+		return 0
+	}
+
+	if it.idle == nil {
+		it.idle = make(chan struct{})
+	}
+
+	idle := it.idle
+
+	it.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-idle:
+		return 0
+	case <-timer.C:
+	}
+
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	return it.count
+}
+
+// resume accepts new work again after a drain
+func (it *inflightTracker) resume() {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	it.draining = false
+
+	if it.resumed != nil {
+		close(it.resumed)
+		it.resumed = nil
+	}
+}
+
+// wait blocks while the tracker is draining, returning false if ctx is done before it resumes
+func (it *inflightTracker) wait(ctx context.Context) bool {
+	it.mutex.Lock()
+	resumed := it.resumed
+	it.mutex.Unlock()
+
+	if resumed == nil {
+		return true
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
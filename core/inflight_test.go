@@ -0,0 +1,71 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// draining reports whether a drain has started on the tracker
+func draining(it *inflightTracker) bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	return it.draining
+}
+
+func TestInflightTracker_DrainIdle(t *testing.T) {
+	sut := inflightTracker{}
+
+	require.Equal(t, 0, sut.drain(time.Second))
+	require.False(t, sut.begin(), "should not accept work once draining")
+}
+
+func TestInflightTracker_DrainWaits(t *testing.T) {
+	sut := inflightTracker{}
+
+	require.True(t, sut.begin())
+
+	abandoned := make(chan int)
+
+	go func() {
+		abandoned <- sut.drain(time.Second)
+	}()
+
+	require.Eventually(t, func() bool { return draining(&sut) }, time.Second, time.Millisecond)
+
+	select {
+	case <-abandoned:
+		require.Fail(t, "should wait for in flight work")
+	default:
+	}
+
+	sut.end()
+
+	require.Equal(t, 0, <-abandoned)
+}
+
+func TestInflightTracker_DrainTimeout(t *testing.T) {
+	sut := inflightTracker{}
+
+	require.True(t, sut.begin())
+	require.True(t, sut.begin())
+
+	require.Equal(t, 2, sut.drain(10*time.Millisecond))
+}
@@ -33,6 +33,26 @@ func NewLogAdapter(client logger.LoggingClient, fields watermill.LogFields) wate
 	}
 }
 
+// NewBackendLogAdapter routes a backend's watermill logs through the EdgeX LoggingClient, tagging
+// each entry with the backend name.  Entries are filtered by the client's current log level, so
+// follow changes to Writable.LogLevel.  A nil client falls back to watermill's standard logger.
+func NewBackendLogAdapter(client logger.LoggingClient, backend string) watermill.LoggerAdapter {
+	if client == nil {
+		return watermill.NewStdLogger(false, false).With(watermill.LogFields{"backend": backend})
+	}
+
+	return NewLogAdapter(client, watermill.LogFields{"backend": backend})
+}
+
+// spoolLogger adapts client for a spool or outbox, which log nothing when it is nil
+func spoolLogger(client logger.LoggingClient) watermill.LoggerAdapter {
+	if client == nil {
+		return nil
+	}
+
+	return NewLogAdapter(client, nil)
+}
+
 func (ewa *edgexWatermillAdapter) Error(msg string, err error, fields watermill.LogFields) {
 	ewa.client.Errorf("%s (%s) - %+v", msg, err.Error(), ewa.combineFields(fields))
 }
@@ -0,0 +1,294 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MetricsNamespace = "edgex_watermill"
+
+	// MetricsRoute is where Register exposes metrics on the application service's webserver
+	MetricsRoute = "/api/v2/watermill/metrics"
+)
+
+var (
+	metricsRegistry = prometheus.NewRegistry()
+
+	metricsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received from the broker.",
+	}, []string{"backend", "topic"})
+
+	metricsProcessed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "messages_processed_total",
+		Help:      "Messages processed successfully and acked.",
+	}, []string{"backend", "topic"})
+
+	metricsFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "messages_failed_total",
+		Help:      "Messages that could not be processed, including each retried attempt.",
+	}, []string{"backend", "topic"})
+
+	metricsUnmarshalErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "unmarshal_errors_total",
+		Help:      "Messages that could not be unmarshaled.",
+	}, []string{"backend", "topic"})
+
+	metricsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: MetricsNamespace,
+		Name:      "messages_published_total",
+		Help:      "Messages published to the broker, labelled by success.",
+	}, []string{"backend", "topic", "success"})
+
+	metricsPublishDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "publish_duration_seconds",
+		Help:      "Time taken to publish to the broker.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "topic"})
+
+	metricsPipelineDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: MetricsNamespace,
+		Name:      "pipeline_duration_seconds",
+		Help:      "Time taken to run the function pipelines for a message.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "topic"})
+
+	metricsInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: MetricsNamespace,
+		Name:      "messages_in_flight",
+		Help:      "Messages currently being handled by triggers.",
+	}, []string{"backend"})
+
+	metricsServers      = make(map[string]bool)
+	metricsServersMutex sync.Mutex
+)
+
+func init() {
+	metricsRegistry.MustRegister(
+		metricsReceived,
+		metricsProcessed,
+		metricsFailed,
+		metricsUnmarshalErrors,
+		metricsPublished,
+		metricsPublishDuration,
+		metricsPipelineDuration,
+		metricsInFlight,
+	)
+}
+
+// MetricsGatherer returns the registry holding all edgex-watermill metrics, eg. to include them
+// in an application's own prometheus endpoint
+func MetricsGatherer() prometheus.Gatherer {
+	return metricsRegistry
+}
+
+// MetricsHandler serves edgex-watermill metrics in the prometheus exposition format
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metricsRegistry, promhttp.HandlerOpts{})
+}
+
+// depthGauge exposes the depth of the spool or outbox stored at path, returning a function that
+// removes the gauge again once it is closed
+func depthGauge(name string, help string, path string, depth func() int) func() {
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace:   MetricsNamespace,
+		Name:        name,
+		Help:        help,
+		ConstLabels: prometheus.Labels{"path": path},
+	}, func() float64 {
+		return float64(depth())
+	})
+
+	if err := metricsRegistry.Register(gauge); err != nil {
+		return func() {}
+	}
+
+	return func() {
+		metricsRegistry.Unregister(gauge)
+	}
+}
+
+// metrics records observations for a single trigger, client or sender.  A nil *metrics
+// is valid and records nothing.
+type metrics struct {
+	mutex         sync.RWMutex
+	backend       string
+	publishTopics bool
+}
+
+// newMetrics returns nil unless metrics are enabled, starting the standalone endpoint if
+// one is configured
+func newMetrics(config *WatermillConfig, logger func(error)) *metrics {
+	if config == nil || !config.Metrics.Enabled {
+		return nil
+	}
+
+	if addr := config.Metrics.ListenAddress; addr != "" {
+		serveMetrics(addr, logger)
+	}
+
+	return &metrics{backend: strings.ToLower(config.Type), publishTopics: config.Metrics.PublishTopicLabels}
+}
+
+// label returns the backend observations are labelled with
+func (m *metrics) label() string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	return m.backend
+}
+
+// publishTopic returns the topic label for a publish, empty unless PublishTopicLabels is set.
+// Received messages are labelled by the subscribed topic, which comes from configuration.
+func (m *metrics) publishTopic(topic string) string {
+	if m.publishTopics {
+		return topic
+	}
+
+	return ""
+}
+
+// setBackend labels further observations with a new backend once a trigger is reconnected
+func (m *metrics) setBackend(backend string) {
+	if m == nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.backend = strings.ToLower(backend)
+}
+
+// serveMetrics starts an HTTP server exposing metrics at /metrics, once per address
+func serveMetrics(addr string, logger func(error)) {
+	metricsServersMutex.Lock()
+	defer metricsServersMutex.Unlock()
+
+	if metricsServers[addr] {
+		return
+	}
+
+	metricsServers[addr] = true
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", MetricsHandler())
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil && logger != nil {
+			logger(err)
+		}
+
+		metricsServersMutex.Lock()
+		delete(metricsServers, addr)
+		metricsServersMutex.Unlock()
+	}()
+}
+
+func (m *metrics) received(topic string) {
+	if m != nil {
+		metricsReceived.WithLabelValues(m.label(), topic).Inc()
+	}
+}
+
+func (m *metrics) processed(topic string) {
+	if m != nil {
+		metricsProcessed.WithLabelValues(m.label(), topic).Inc()
+	}
+}
+
+func (m *metrics) failed(topic string, err error) {
+	if m == nil {
+		return
+	}
+
+	metricsFailed.WithLabelValues(m.label(), topic).Inc()
+
+	if pe, ok := err.(*processingError); ok && pe.class == ErrorClassUnmarshal {
+		m.unmarshalFailed(topic)
+	}
+}
+
+func (m *metrics) unmarshalFailed(topic string) {
+	if m != nil {
+		metricsUnmarshalErrors.WithLabelValues(m.label(), topic).Inc()
+	}
+}
+
+func (m *metrics) pipelineDuration(topic string, started time.Time) {
+	if m != nil {
+		metricsPipelineDuration.WithLabelValues(m.label(), topic).Observe(time.Since(started).Seconds())
+	}
+}
+
+func (m *metrics) inFlight(delta float64) {
+	if m != nil {
+		metricsInFlight.WithLabelValues(m.label()).Add(delta)
+	}
+}
+
+// metricsPublisher records the outcome and latency of each publish
+type metricsPublisher struct {
+	message.Publisher
+	metrics *metrics
+}
+
+func (mp *metricsPublisher) Publish(topic string, messages ...*message.Message) error {
+	started := time.Now()
+
+	err := mp.Publisher.Publish(topic, messages...)
+
+	label := mp.metrics.publishTopic(topic)
+
+	metricsPublishDuration.WithLabelValues(mp.metrics.label(), label).Observe(time.Since(started).Seconds())
+
+	success := "true"
+
+	if err != nil {
+		success = "false"
+	}
+
+	metricsPublished.WithLabelValues(mp.metrics.label(), label, success).Add(float64(len(messages)))
+
+	return err
+}
+
+func (mp *metricsPublisher) SpoolDepth() int {
+	return spoolDepth(mp.Publisher)
+}
+
+// instrumentPublisher wraps pub to record publish metrics when they are enabled
+func instrumentPublisher(pub message.Publisher, m *metrics) message.Publisher {
+	if pub == nil || m == nil {
+		return pub
+	}
+
+	return &metricsPublisher{Publisher: pub, metrics: m}
+}
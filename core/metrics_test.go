@@ -0,0 +1,153 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewMetrics_Disabled(t *testing.T) {
+	require.Nil(t, newMetrics(nil, nil))
+	require.Nil(t, newMetrics(&WatermillConfig{Type: "kafka"}, nil))
+
+	pub := &mockPublisher{}
+
+	require.Same(t, pub, instrumentPublisher(pub, nil))
+}
+
+func TestMetricsPublisher(t *testing.T) {
+	backend := uuid.NewString()
+	topic := uuid.NewString()
+
+	m := newMetrics(&WatermillConfig{Type: backend, Metrics: MetricsConfig{Enabled: true, PublishTopicLabels: true}}, nil)
+
+	pub := &flakyPublisher{}
+
+	sut := instrumentPublisher(pub, m)
+
+	require.NoError(t, sut.Publish(topic, message.NewMessage(uuid.NewString(), nil), message.NewMessage(uuid.NewString(), nil)))
+
+	pub.setDown(true)
+
+	require.Error(t, sut.Publish(topic, message.NewMessage(uuid.NewString(), nil)))
+
+	require.Equal(t, float64(2), testutil.ToFloat64(metricsPublished.WithLabelValues(backend, topic, "true")))
+	require.Equal(t, float64(1), testutil.ToFloat64(metricsPublished.WithLabelValues(backend, topic, "false")))
+}
+
+func TestMetricsPublisher_NoTopicLabels(t *testing.T) {
+	backend := uuid.NewString()
+
+	sut := instrumentPublisher(&flakyPublisher{}, newMetrics(&WatermillConfig{Type: backend, Metrics: MetricsConfig{Enabled: true}}, nil))
+
+	require.NoError(t, sut.Publish(uuid.NewString(), message.NewMessage(uuid.NewString(), nil)))
+	require.NoError(t, sut.Publish(uuid.NewString(), message.NewMessage(uuid.NewString(), nil)))
+
+	require.Equal(t, float64(2), testutil.ToFloat64(metricsPublished.WithLabelValues(backend, "", "true")))
+}
+
+func TestInput_Metrics(t *testing.T) {
+	backend := uuid.NewString()
+	topic := uuid.NewString()
+
+	sut, _ := newInputTestTrigger(t, nil, errors.New("pipeline"))
+
+	sut.metrics = newMetrics(&WatermillConfig{Type: backend, Metrics: MetricsConfig{Enabled: true}}, nil)
+
+	sut.input(message.NewMessage(uuid.NewString(), []byte("{}")), topic)
+	sut.input(message.NewMessage(uuid.NewString(), []byte("{}")), topic)
+
+	require.Equal(t, float64(1), testutil.ToFloat64(metricsFailed.WithLabelValues(backend, topic)))
+	require.Equal(t, float64(1), testutil.ToFloat64(metricsProcessed.WithLabelValues(backend, topic)))
+	duration := &dto.Metric{}
+
+	require.NoError(t, metricsPipelineDuration.WithLabelValues(backend, topic).(prometheus.Histogram).Write(duration))
+	require.Equal(t, uint64(2), duration.GetHistogram().GetSampleCount())
+}
+
+func TestMetricsHandler(t *testing.T) {
+	backend := uuid.NewString()
+
+	newMetrics(&WatermillConfig{Type: backend, Metrics: MetricsConfig{Enabled: true}}, nil).received("topic")
+
+	rec := httptest.NewRecorder()
+
+	MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", MetricsRoute, nil))
+
+	require.Equal(t, 200, rec.Code)
+	require.Contains(t, rec.Body.String(), MetricsNamespace+"_messages_received_total")
+	require.Contains(t, rec.Body.String(), backend)
+}
+
+func TestDepthGauges(t *testing.T) {
+	pub := &flakyPublisher{down: true}
+
+	spoolPath := filepath.Join(t.TempDir(), "spool.db")
+	outboxPath := filepath.Join(t.TempDir(), "outbox.db")
+
+	spool, err := NewSpoolingPublisher(pub, SpoolConfig{Path: spoolPath, RetryInterval: "1h"}, nil)
+
+	require.NoError(t, err)
+
+	outbox, err := NewOutbox(pub, OutboxConfig{Path: outboxPath, RelayInterval: "1h"}, nil)
+
+	require.NoError(t, err)
+
+	require.NoError(t, spool.Publish("topic", message.NewMessage(uuid.NewString(), nil)))
+	require.NoError(t, outbox.Publish("topic", message.NewMessage(uuid.NewString(), nil), message.NewMessage(uuid.NewString(), nil)))
+
+	require.Equal(t, float64(1), gaugeValue(t, MetricsNamespace+"_spool_depth", spoolPath))
+	require.Equal(t, float64(2), gaugeValue(t, MetricsNamespace+"_outbox_depth", outboxPath))
+
+	require.NoError(t, spool.Close())
+	require.NoError(t, outbox.Close())
+
+	require.Equal(t, float64(-1), gaugeValue(t, MetricsNamespace+"_spool_depth", spoolPath))
+	require.Equal(t, float64(-1), gaugeValue(t, MetricsNamespace+"_outbox_depth", outboxPath))
+}
+
+// gaugeValue reads the gauge labelled with path from the registry, -1 if it is not there
+func gaugeValue(t *testing.T, name string, path string) float64 {
+	families, err := MetricsGatherer().Gather()
+
+	require.NoError(t, err)
+
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+
+		for _, m := range family.GetMetric() {
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "path" && label.GetValue() == path {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+
+	return -1
+}
@@ -98,11 +98,11 @@ type mockMarshaler struct {
 }
 
 // Execute provides a mock function with given fields: envelope
-func (_m *mockMarshaler) Execute(envelope types.MessageEnvelope, enc binaryModifier) (*message.Message, error) {
+func (_m *mockMarshaler) Execute(envelope types.MessageEnvelope, enc BinaryModifier) (*message.Message, error) {
 	ret := _m.Called(envelope, enc)
 
 	var r0 *message.Message
-	if rf, ok := ret.Get(0).(func(types.MessageEnvelope, binaryModifier) *message.Message); ok {
+	if rf, ok := ret.Get(0).(func(types.MessageEnvelope, BinaryModifier) *message.Message); ok {
 		r0 = rf(envelope, enc)
 	} else {
 		if ret.Get(0) != nil {
@@ -111,7 +111,7 @@ func (_m *mockMarshaler) Execute(envelope types.MessageEnvelope, enc binaryModif
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(types.MessageEnvelope, binaryModifier) error); ok {
+	if rf, ok := ret.Get(1).(func(types.MessageEnvelope, BinaryModifier) error); ok {
 		r1 = rf(envelope, enc)
 	} else {
 		r1 = ret.Error(1)
@@ -126,18 +126,18 @@ type mockUnmarshaler struct {
 }
 
 // Execute provides a mock function with given fields: _a0
-func (_m *mockUnmarshaler) Execute(msg *message.Message, dec binaryModifier) (types.MessageEnvelope, error) {
+func (_m *mockUnmarshaler) Execute(msg *message.Message, dec BinaryModifier) (types.MessageEnvelope, error) {
 	ret := _m.Called(msg, dec)
 
 	var r0 types.MessageEnvelope
-	if rf, ok := ret.Get(0).(func(*message.Message, binaryModifier) types.MessageEnvelope); ok {
+	if rf, ok := ret.Get(0).(func(*message.Message, BinaryModifier) types.MessageEnvelope); ok {
 		r0 = rf(msg, dec)
 	} else {
 		r0 = ret.Get(0).(types.MessageEnvelope)
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(*message.Message, binaryModifier) error); ok {
+	if rf, ok := ret.Get(1).(func(*message.Message, BinaryModifier) error); ok {
 		r1 = rf(msg, dec)
 	} else {
 		r1 = ret.Error(1)
@@ -0,0 +1,216 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill-kafka/v2/pkg/kafka"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/fxamacker/cbor/v2"
+	"hash/fnv"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	OrderingKeyCorrelationID  = "correlationid"
+	OrderingKeyPartitionKey   = "partitionkey"
+	OrderingKeyMetadataPrefix = "metadata:"
+	OrderingKeyEventPrefix    = "event:"
+)
+
+// orderingKey describes where the key used to order messages is read from
+type orderingKey struct {
+	source string
+	name   string
+}
+
+func parseOrderingKey(spec string) (*orderingKey, error) {
+	spec = strings.TrimSpace(spec)
+	lower := strings.ToLower(spec)
+
+	switch {
+	case spec == "":
+		return nil, nil
+	case lower == OrderingKeyCorrelationID:
+		return &orderingKey{source: OrderingKeyCorrelationID}, nil
+	case lower == OrderingKeyPartitionKey:
+		return &orderingKey{source: OrderingKeyPartitionKey}, nil
+	case strings.HasPrefix(lower, OrderingKeyMetadataPrefix) && len(spec) > len(OrderingKeyMetadataPrefix):
+		return &orderingKey{source: OrderingKeyMetadataPrefix, name: spec[len(OrderingKeyMetadataPrefix):]}, nil
+	case strings.HasPrefix(lower, OrderingKeyEventPrefix) && len(spec) > len(OrderingKeyEventPrefix):
+		return &orderingKey{source: OrderingKeyEventPrefix, name: spec[len(OrderingKeyEventPrefix):]}, nil
+	default:
+		return nil, fmt.Errorf("invalid ordering key specified: %s", spec)
+	}
+}
+
+// orderingKey extracts the key for a message, returning an empty key if none can be found
+func (t *watermillTrigger) orderingKey(msg *message.Message) string {
+	switch t.ordering.source {
+	case OrderingKeyCorrelationID:
+		if id := msg.Metadata.Get(middleware.CorrelationIDMetadataKey); id != "" {
+			return id
+		}
+		return msg.UUID
+	case OrderingKeyPartitionKey:
+		return partitionKey(msg)
+	case OrderingKeyMetadataPrefix:
+		return msg.Metadata.Get(t.ordering.name)
+	case OrderingKeyEventPrefix:
+		// decoded again when processed, the pipeline may need a different shape than we do here
+		conn := t.acquire()
+		env, err := conn.unmarshaler(msg, conn.decryptor)
+		conn.release()
+
+		if err != nil || len(env.Payload) == 0 {
+			return ""
+		}
+
+		return eventField(env.Payload, t.ordering.name)
+	}
+
+	return ""
+}
+
+// partitionKey reads the key a message was partitioned by when published through a PartitionKey,
+// otherwise the kafka partition it was received from
+func partitionKey(msg *message.Message) string {
+	if key := msg.Metadata.Get(PartitionKeyMetadataKey); key != "" {
+		return key
+	}
+
+	if partition, found := kafka.MessagePartitionFromCtx(msg.Context()); found {
+		return "partition:" + strconv.Itoa(int(partition))
+	}
+
+	return ""
+}
+
+// eventField reads a top level field from a JSON or CBOR encoded event, or from the event
+// wrapped in an AddEventRequest
+func eventField(payload []byte, name string) string {
+	fields := make(map[string]interface{})
+
+	var err error
+
+	if payload[0] == byte('{') {
+		err = json.Unmarshal(payload, &fields)
+	} else {
+		err = cbor.Unmarshal(payload, &fields)
+	}
+
+	if err != nil {
+		return ""
+	}
+
+	if value, found := lookupField(fields, name); found {
+		return fmt.Sprintf("%v", value)
+	}
+
+	if event, found := lookupField(fields, "event"); found {
+		switch e := event.(type) {
+		case map[string]interface{}:
+			if value, found := lookupField(e, name); found {
+				return fmt.Sprintf("%v", value)
+			}
+		case map[interface{}]interface{}:
+			for k, v := range e {
+				if ks, ok := k.(string); ok && strings.EqualFold(ks, name) {
+					return fmt.Sprintf("%v", v)
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+func lookupField(fields map[string]interface{}, name string) (interface{}, bool) {
+	for k, v := range fields {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+type orderedMessage struct {
+	msg   *message.Message
+	topic string
+}
+
+// orderedDispatcher shards messages across a fixed set of workers so that messages sharing
+// a key are processed one at a time in the order received.  Messages without a key are not
+// ordered and are spread across the workers in turn.
+type orderedDispatcher struct {
+	shards []chan orderedMessage
+	next   uint32
+}
+
+func newOrderedDispatcher(ctx context.Context, workers int, handler func(*message.Message, string)) *orderedDispatcher {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	od := &orderedDispatcher{
+		shards: make([]chan orderedMessage, workers),
+	}
+
+	for i := range od.shards {
+		od.shards[i] = make(chan orderedMessage)
+
+		go func(shard <-chan orderedMessage) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case om := <-shard:
+					handler(om.msg, om.topic)
+				}
+			}
+		}(od.shards[i])
+	}
+
+	return od
+}
+
+// dispatch blocks until the worker for the given key accepts the message, returning false if
+// the context is done first
+func (od *orderedDispatcher) dispatch(ctx context.Context, key string, msg *message.Message, topic string) bool {
+	var shard uint32
+
+	if key == "" {
+		shard = atomic.AddUint32(&od.next, 1)
+	} else {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		shard = h.Sum32()
+	}
+
+	select {
+	case od.shards[shard%uint32(len(od.shards))] <- orderedMessage{msg: msg, topic: topic}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
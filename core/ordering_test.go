@@ -0,0 +1,169 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseOrderingKey(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected *orderingKey
+		err      bool
+	}{
+		{"", nil, false},
+		{"CorrelationID", &orderingKey{source: OrderingKeyCorrelationID}, false},
+		{"PartitionKey", &orderingKey{source: OrderingKeyPartitionKey}, false},
+		{"metadata:deviceName", &orderingKey{source: OrderingKeyMetadataPrefix, name: "deviceName"}, false},
+		{"Event:deviceName", &orderingKey{source: OrderingKeyEventPrefix, name: "deviceName"}, false},
+		{"metadata:", nil, true},
+		{"random", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			key, err := parseOrderingKey(tt.spec)
+
+			if tt.err {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.expected, key)
+			}
+		})
+	}
+}
+
+func TestOrderingKey_CorrelationID(t *testing.T) {
+	sut := watermillTrigger{ordering: &orderingKey{source: OrderingKeyCorrelationID}}
+
+	msg := message.NewMessage(uuid.NewString(), nil)
+
+	require.Equal(t, msg.UUID, sut.orderingKey(msg), "should fall back to UUID")
+
+	msg.Metadata.Set(middleware.CorrelationIDMetadataKey, "correlation")
+
+	require.Equal(t, "correlation", sut.orderingKey(msg))
+}
+
+func TestOrderingKey_PartitionKey(t *testing.T) {
+	sut := watermillTrigger{ordering: &orderingKey{source: OrderingKeyPartitionKey}}
+
+	msg := message.NewMessage(uuid.NewString(), nil)
+
+	require.Equal(t, "", sut.orderingKey(msg), "should not fall back to UUID")
+
+	msg.Metadata.Set(PartitionKeyMetadataKey, "thermostat")
+
+	require.Equal(t, "thermostat", sut.orderingKey(msg))
+}
+
+func TestOrderingKey_Metadata(t *testing.T) {
+	sut := watermillTrigger{ordering: &orderingKey{source: OrderingKeyMetadataPrefix, name: "device"}}
+
+	msg := message.NewMessage(uuid.NewString(), nil)
+	msg.Metadata.Set("device", "thermostat")
+
+	require.Equal(t, "thermostat", sut.orderingKey(msg))
+}
+
+func TestOrderingKey_Event(t *testing.T) {
+	sut := watermillTrigger{
+		ordering:    &orderingKey{source: OrderingKeyEventPrefix, name: "deviceName"},
+		unmarshaler: (&RawWireFormat{}).Unmarshal,
+	}
+
+	msg := message.NewMessage(uuid.NewString(), []byte(`{"apiVersion":"v2","event":{"deviceName":"thermostat"}}`))
+
+	require.Equal(t, "thermostat", sut.orderingKey(msg))
+}
+
+func TestEventField(t *testing.T) {
+	wrapped, err := cbor.Marshal(map[string]interface{}{"event": map[string]interface{}{"deviceName": "cbor"}})
+
+	require.NoError(t, err)
+
+	require.Equal(t, "json", eventField([]byte(`{"devicename":"json"}`), "deviceName"))
+	require.Equal(t, "cbor", eventField(wrapped, "deviceName"))
+	require.Equal(t, "", eventField([]byte(`{"sourceName":"json"}`), "deviceName"))
+	require.Equal(t, "", eventField([]byte(`not an event`), "deviceName"))
+}
+
+func TestOrderedDispatcher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mu := sync.Mutex{}
+	received := make(map[string][]string)
+	wg := sync.WaitGroup{}
+
+	sut := newOrderedDispatcher(ctx, 4, func(msg *message.Message, topic string) {
+		defer wg.Done()
+		mu.Lock()
+		defer mu.Unlock()
+		received[topic] = append(received[topic], msg.UUID)
+	})
+
+	sent := make(map[string][]string)
+
+	for i := 0; i < 50; i++ {
+		for _, key := range []string{"a", "b", "c"} {
+			msg := message.NewMessage(uuid.NewString(), nil)
+			sent[key] = append(sent[key], msg.UUID)
+			wg.Add(1)
+			require.True(t, sut.dispatch(ctx, key, msg, key))
+		}
+	}
+
+	wg.Wait()
+
+	require.Equal(t, sent, received, "messages sharing a key should be processed in order")
+}
+
+func TestOrderedDispatcher_EmptyKey(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	release := make(chan struct{})
+	wg := sync.WaitGroup{}
+
+	sut := newOrderedDispatcher(ctx, 4, func(msg *message.Message, topic string) {
+		defer wg.Done()
+		<-release
+	})
+
+	dispatchCtx, dispatchCancel := context.WithTimeout(ctx, time.Second)
+	defer dispatchCancel()
+
+	// each worker blocks, so a second message sent to the same worker could not be dispatched
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		require.True(t, sut.dispatch(dispatchCtx, "", message.NewMessage(uuid.NewString(), nil), "topic"), "messages without a key should not share a worker")
+	}
+
+	close(release)
+	wg.Wait()
+}
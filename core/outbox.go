@@ -0,0 +1,325 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/hashicorp/go-multierror"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// OutboxTxContextKey identifies the outbox transaction a trigger opened for the message being
+	// processed, see WithOutboxTx
+	OutboxTxContextKey = "outboxtx"
+
+	defaultOutboxRelayInterval = time.Second
+
+	outboxBucket = "outbox"
+)
+
+var (
+	outboxMutex sync.Mutex
+	outboxes    = make(map[string]*Outbox)
+
+	// open transactions shared with pipelines, keyed by the id stored in their context
+	outboxTxMutex sync.Mutex
+	outboxTxs     = make(map[string]*OutboxTx)
+)
+
+// Outbox is a publisher that writes messages to a local bolt database, leaving a relay to
+// forward them to the broker and remove them once delivered.  Pipeline functions run by a
+// trigger can write their own state in the same transaction as their output using WithOutboxTx.
+type Outbox struct {
+	pub      message.Publisher
+	queue    *diskQueue
+	path     string
+	logger   watermill.LoggerAdapter
+	interval time.Duration
+	notify   chan struct{}
+	gauge     func()
+	closing   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// OutboxTx is a bolt transaction that can also publish messages to the outbox.  Messages are
+// only relayed if the transaction commits.
+type OutboxTx struct {
+	*bolt.Tx
+	outbox *Outbox
+	mutex  sync.Mutex
+}
+
+// Publish stores messages for the relay as part of the transaction
+func (tx *OutboxTx) Publish(topic string, messages ...*message.Message) error {
+	return tx.outbox.queue.pushTx(tx.Tx, topic, messages...)
+}
+
+func NewOutbox(pub message.Publisher, config OutboxConfig, logger watermill.LoggerAdapter) (*Outbox, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("outbox path must be specified")
+	}
+
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	interval := defaultOutboxRelayInterval
+
+	if config.RelayInterval != "" {
+		var err error
+
+		if interval, err = time.ParseDuration(config.RelayInterval); err != nil {
+			return nil, fmt.Errorf("invalid outbox relay interval: %s", err.Error())
+		}
+	}
+
+	path, err := filepath.Abs(config.Path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+
+	if _, exists := outboxes[path]; exists {
+		return nil, fmt.Errorf("outbox already open: %s", path)
+	}
+
+	queue, err := openDiskQueue(path, outboxBucket, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ob := &Outbox{
+		pub:      pub,
+		queue:    queue,
+		path:     path,
+		logger:   logger.With(watermill.LogFields{"outbox": path}),
+		interval: interval,
+		notify:   make(chan struct{}, 1),
+		closing:  make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+
+	outboxes[path] = ob
+
+	ob.gauge = depthGauge("outbox_depth", "Messages waiting in an outbox to be relayed.", path, ob.Depth)
+
+	go ob.relay()
+
+	return ob, nil
+}
+
+// LookupOutbox returns the open outbox stored at path, allowing pipeline functions to share
+// the database used by the trigger
+func LookupOutbox(path string) (*Outbox, error) {
+	abs, err := filepath.Abs(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	outboxMutex.Lock()
+	defer outboxMutex.Unlock()
+
+	ob, found := outboxes[abs]
+
+	if !found {
+		return nil, fmt.Errorf("no outbox open at %s", abs)
+	}
+
+	return ob, nil
+}
+
+// Publish stores messages for the relay in their own transaction
+func (ob *Outbox) Publish(topic string, messages ...*message.Message) error {
+	return ob.Update(func(tx *OutboxTx) error {
+		return tx.Publish(topic, messages...)
+	})
+}
+
+// Update runs fn in a read-write transaction, relaying any messages it publishes once committed
+func (ob *Outbox) Update(fn func(tx *OutboxTx) error) error {
+	err := ob.queue.db.Update(func(tx *bolt.Tx) error {
+		return fn(&OutboxTx{Tx: tx, outbox: ob})
+	})
+
+	if err == nil {
+		select {
+		case ob.notify <- struct{}{}:
+		default:
+		}
+	}
+
+	return err
+}
+
+// share runs fn in a transaction pipelines processing ctx can join with WithOutboxTx, so
+// their state and output are committed together or not at all
+func (ob *Outbox) share(ctx interfaces.AppFunctionContext, fn func() error) error {
+	return ob.Update(func(tx *OutboxTx) error {
+		id := watermill.NewUUID()
+
+		outboxTxMutex.Lock()
+		outboxTxs[id] = tx
+		outboxTxMutex.Unlock()
+
+		defer func() {
+			outboxTxMutex.Lock()
+			delete(outboxTxs, id)
+			outboxTxMutex.Unlock()
+		}()
+
+		ctx.AddValue(OutboxTxContextKey, id)
+		defer ctx.RemoveValue(OutboxTxContextKey)
+
+		return fn()
+	})
+}
+
+// WithOutboxTx runs fn in the outbox transaction the trigger opened for the message ctx belongs
+// to.  Everything fn writes, along with the pipeline's output, is committed once every pipeline
+// has finished, or rolled back if any of them fail.  Pipelines matching the same message share
+// the transaction, so fn is only run for one of them at a time.  Calling Update on the outbox
+// from a pipeline instead would wait on the trigger's transaction forever.
+func WithOutboxTx(ctx interfaces.AppFunctionContext, fn func(tx *OutboxTx) error) error {
+	id, found := ctx.GetValue(OutboxTxContextKey)
+
+	if !found {
+		return fmt.Errorf("no outbox transaction in context")
+	}
+
+	outboxTxMutex.Lock()
+	tx, found := outboxTxs[id]
+	outboxTxMutex.Unlock()
+
+	if !found {
+		return fmt.Errorf("outbox transaction %s is no longer open", id)
+	}
+
+	tx.mutex.Lock()
+	defer tx.mutex.Unlock()
+
+	return fn(tx)
+}
+
+// Depth returns the number of messages waiting to be relayed
+func (ob *Outbox) Depth() int {
+	return ob.queue.depth()
+}
+
+// SpoolDepth reports the depth of the spool messages are relayed through, if any
+func (ob *Outbox) SpoolDepth() int {
+	return spoolDepth(ob.pub)
+}
+
+// Close stops relaying and closes the decorated publisher and outbox file.  Closing more than
+// once returns the result of the first close.
+func (ob *Outbox) Close() error {
+	ob.closeOnce.Do(func() {
+		ob.gauge()
+
+		close(ob.closing)
+		<-ob.closed
+
+		outboxMutex.Lock()
+		delete(outboxes, ob.path)
+		outboxMutex.Unlock()
+
+		if err := ob.pub.Close(); err != nil {
+			ob.closeErr = multierror.Append(ob.closeErr, err)
+		}
+
+		if err := ob.queue.close(); err != nil {
+			ob.closeErr = multierror.Append(ob.closeErr, err)
+		}
+	})
+
+	return ob.closeErr
+}
+
+func (ob *Outbox) relay() {
+	defer close(ob.closed)
+
+	ticker := time.NewTicker(ob.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ob.closing:
+			return
+		case <-ob.notify:
+		case <-ticker.C:
+		}
+
+		for ob.relayNext() {
+			select {
+			case <-ob.closing:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// relayNext publishes the oldest stored message, returning false if there is nothing to relay
+// or it could not be delivered.  A crash between publishing and removal will see the message
+// delivered again.
+func (ob *Outbox) relayNext() bool {
+	key, topic, msg, err := ob.queue.peek()
+
+	if err != nil {
+		ob.logger.Error("Failed to read from outbox", err, nil)
+		return false
+	}
+
+	if key == nil {
+		return false
+	}
+
+	if err = ob.pub.Publish(topic, msg); err != nil {
+		ob.logger.Error("Failed to relay message", err, watermill.LogFields{"topic": topic, "uuid": msg.UUID})
+		return false
+	}
+
+	if err = ob.queue.remove(key); err != nil {
+		ob.logger.Error("Failed to mark relayed message delivered", err, watermill.LogFields{"topic": topic, "uuid": msg.UUID})
+		return false
+	}
+
+	return true
+}
+
+// outboxPublisher wraps pub with an Outbox when one is configured
+func outboxPublisher(pub message.Publisher, config *WatermillConfig, logger watermill.LoggerAdapter) (message.Publisher, error) {
+	if pub == nil || config == nil || config.Outbox.Path == "" {
+		return pub, nil
+	}
+
+	return NewOutbox(pub, config.Outbox, logger)
+}
@@ -0,0 +1,247 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOutbox_Publish(t *testing.T) {
+	pub := &flakyPublisher{}
+
+	sut, err := NewOutbox(pub, OutboxConfig{Path: filepath.Join(t.TempDir(), "outbox.db")}, nil)
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	msg := message.NewMessage(uuid.NewString(), []byte("OK"))
+
+	require.NoError(t, sut.Publish("topic", msg))
+
+	require.Eventually(t, func() bool {
+		return len(pub.publishedUUIDs()) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	require.Equal(t, []string{msg.UUID}, pub.publishedUUIDs())
+	require.Equal(t, 0, sut.Depth())
+}
+
+func TestOutbox_RelayRetries(t *testing.T) {
+	pub := &flakyPublisher{down: true}
+
+	sut, err := NewOutbox(pub, OutboxConfig{Path: filepath.Join(t.TempDir(), "outbox.db"), RelayInterval: "5ms"}, nil)
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	first := message.NewMessage(uuid.NewString(), nil)
+	second := message.NewMessage(uuid.NewString(), nil)
+
+	require.NoError(t, sut.Publish("topic", first))
+	require.NoError(t, sut.Publish("topic", second))
+	require.Equal(t, 2, sut.Depth())
+
+	pub.setDown(false)
+
+	require.Eventually(t, func() bool {
+		return sut.Depth() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	require.Equal(t, []string{first.UUID, second.UUID}, pub.publishedUUIDs())
+}
+
+func TestOutbox_Update(t *testing.T) {
+	pub := &flakyPublisher{down: true}
+
+	sut, err := NewOutbox(pub, OutboxConfig{Path: filepath.Join(t.TempDir(), "outbox.db"), RelayInterval: "1h"}, nil)
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	err = sut.Update(func(tx *OutboxTx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("readings"))
+
+		if err != nil {
+			return err
+		}
+
+		if err = b.Put([]byte("key"), []byte("value")); err != nil {
+			return err
+		}
+
+		return tx.Publish("topic", message.NewMessage(uuid.NewString(), nil))
+	})
+
+	require.NoError(t, err)
+	require.Equal(t, 1, sut.Depth())
+}
+
+func TestOutbox_UpdateRollback(t *testing.T) {
+	pub := &flakyPublisher{down: true}
+
+	sut, err := NewOutbox(pub, OutboxConfig{Path: filepath.Join(t.TempDir(), "outbox.db"), RelayInterval: "1h"}, nil)
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	err = sut.Update(func(tx *OutboxTx) error {
+		if err := tx.Publish("topic", message.NewMessage(uuid.NewString(), nil)); err != nil {
+			return err
+		}
+
+		return errors.New("pipeline failed")
+	})
+
+	require.Error(t, err)
+	require.Equal(t, 0, sut.Depth())
+}
+
+// newOutboxTestTrigger processes messages with a pipeline that records state through
+// WithOutboxTx and publishes output, failing with pipelineErr
+func newOutboxTestTrigger(t *testing.T, pipelineErr error) (*watermillTrigger, *Outbox) {
+	outbox, err := NewOutbox(&flakyPublisher{down: true}, OutboxConfig{Path: filepath.Join(t.TempDir(), "outbox.db"), RelayInterval: "1h"}, nil)
+
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = outbox.Close() })
+
+	unmarshaler := mockUnmarshaler{}
+	unmarshaler.On("Execute", mock.Anything, mock.Anything).Return(types.MessageEnvelope{}, nil)
+
+	marshaler := mockMarshaler{}
+	marshaler.On("Execute", mock.Anything, mock.Anything).Return(message.NewMessage(uuid.NewString(), []byte("output")), nil)
+
+	sut := &watermillTrigger{
+		pub:         outbox,
+		marshaler:   marshaler.Execute,
+		unmarshaler: unmarshaler.Execute,
+		watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{
+			PublishTopic: "output",
+		}},
+		edgeXConfig: interfaces.TriggerConfig{
+			Logger: logger.NewMockClient(),
+			ContextBuilder: func(env types.MessageEnvelope) interfaces.AppFunctionContext {
+				return pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient())
+			},
+			MessageReceived: func(ctx interfaces.AppFunctionContext, envelope types.MessageEnvelope, responseHandler interfaces.PipelineResponseHandler) error {
+				err := WithOutboxTx(ctx, func(tx *OutboxTx) error {
+					b, err := tx.CreateBucketIfNotExists([]byte("readings"))
+
+					if err != nil {
+						return err
+					}
+
+					return b.Put([]byte("key"), []byte("value"))
+				})
+
+				if err != nil {
+					return err
+				}
+
+				ctx.SetResponseData([]byte("output"))
+
+				if err = responseHandler(ctx, &interfaces.FunctionPipeline{Id: "default"}); err != nil {
+					return err
+				}
+
+				return pipelineErr
+			},
+		},
+	}
+
+	return sut, outbox
+}
+
+// outboxState returns the value the test pipeline stored in the outbox database, if committed
+func outboxState(t *testing.T, outbox *Outbox) []byte {
+	var value []byte
+
+	err := outbox.queue.db.View(func(tx *bolt.Tx) error {
+		if b := tx.Bucket([]byte("readings")); b != nil {
+			value = append([]byte{}, b.Get([]byte("key"))...)
+		}
+		return nil
+	})
+
+	require.NoError(t, err)
+
+	return value
+}
+
+func TestOutbox_TriggerPipeline(t *testing.T) {
+	sut, outbox := newOutboxTestTrigger(t, nil)
+
+	err := sut.process(sut.acquire(), message.NewMessage(uuid.NewString(), nil), "input")
+
+	require.NoError(t, err)
+	require.Equal(t, 1, outbox.Depth())
+	require.Equal(t, []byte("value"), outboxState(t, outbox))
+}
+
+func TestOutbox_TriggerPipelineFailure(t *testing.T) {
+	sut, outbox := newOutboxTestTrigger(t, errors.New("pipeline failed"))
+
+	err := sut.process(sut.acquire(), message.NewMessage(uuid.NewString(), nil), "input")
+
+	require.Error(t, err)
+	require.Equal(t, 0, outbox.Depth(), "output should be rolled back with the pipeline")
+	require.Empty(t, outboxState(t, outbox))
+}
+
+func TestWithOutboxTx_NoTransaction(t *testing.T) {
+	ctx := pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient())
+
+	err := WithOutboxTx(ctx, func(tx *OutboxTx) error {
+		return nil
+	})
+
+	require.Error(t, err)
+}
+
+func TestLookupOutbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "outbox.db")
+
+	sut, err := NewOutbox(&flakyPublisher{}, OutboxConfig{Path: path}, nil)
+
+	require.NoError(t, err)
+
+	found, err := LookupOutbox(path)
+
+	require.NoError(t, err)
+	require.Same(t, sut, found)
+
+	_, err = NewOutbox(&flakyPublisher{}, OutboxConfig{Path: path}, nil)
+
+	require.Error(t, err, "should not open the same outbox twice")
+
+	require.NoError(t, sut.Close())
+
+	_, err = LookupOutbox(path)
+
+	require.Error(t, err)
+}
@@ -0,0 +1,60 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"strings"
+)
+
+// PartitionKey sources understood by backends that partition topics.  Any other PartitionKey
+// containing placeholders (eg. "{devicename}") is resolved from the pipeline context when
+// publishing and carried in PartitionKeyMetadataKey.
+const (
+	PartitionKeyCorrelationID  = OrderingKeyCorrelationID
+	PartitionKeyMetadataPrefix = OrderingKeyMetadataPrefix
+
+	PartitionKeyMetadataKey = "edgex_partition_key"
+)
+
+// IsPartitionKeyTemplate reports whether a PartitionKey is resolved from the pipeline context
+func IsPartitionKeyTemplate(spec string) bool {
+	return strings.Contains(spec, "{")
+}
+
+// setPartitionKey resolves a PartitionKey template for msg, ignoring other key sources.  A template
+// that can't be resolved leaves msg without a key, to be partitioned by its UUID.
+func setPartitionKey(ctx interfaces.AppFunctionContext, msg *message.Message, spec string) {
+	if !IsPartitionKeyTemplate(spec) {
+		return
+	}
+
+	key, err := ctx.ApplyValues(spec)
+
+	if err != nil {
+		ctx.LoggingClient().Warn(fmt.Sprintf("Unable to resolve partition key, publishing without one: %s", err.Error()))
+		return
+	}
+
+	if msg.Metadata == nil {
+		msg.Metadata = make(message.Metadata)
+	}
+
+	msg.Metadata.Set(PartitionKeyMetadataKey, key)
+}
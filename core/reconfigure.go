@@ -0,0 +1,411 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReconfigurableTrigger is implemented by triggers that can apply configuration changes while running
+type ReconfigurableTrigger interface {
+	Reconfigure(config WatermillConfig) error
+}
+
+var _ ReconfigurableTrigger = &watermillTrigger{}
+
+// triggerConnection holds a publisher and subscriber built for an updated configuration
+type triggerConnection struct {
+	pub        message.Publisher
+	sub        message.Subscriber
+	format     WireFormat
+	protection dataProtection
+}
+
+// close disconnects a connection that was never put into use
+func (tc *triggerConnection) close() {
+	_ = tc.sub.Close()
+	_ = tc.pub.Close()
+}
+
+// connectionSnapshot is the connection a message is handled with, along with its retry and
+// dedup policies.  Each message uses a single snapshot throughout, so a concurrent Reconfigure
+// never mixes the publisher, wire format or encryption of two connections, and a connection is
+// only closed once its snapshots are released.
+type connectionSnapshot struct {
+	pub            message.Publisher
+	marshaler      WatermillMarshaler
+	unmarshaler    WatermillUnmarshaler
+	traceMarshaler TraceContextMarshaler
+	encryptor      BinaryModifier
+	decryptor      BinaryModifier
+	retry          *retryPolicy
+	dedup          *deduplicator
+	users          *sync.WaitGroup
+}
+
+// acquire takes a snapshot of the trigger's current connection, to be released once done with
+func (t *watermillTrigger) acquire() *connectionSnapshot {
+	t.connMutex.RLock()
+	defer t.connMutex.RUnlock()
+
+	if t.users != nil {
+		t.users.Add(1)
+	}
+
+	return &connectionSnapshot{
+		pub:            t.pub,
+		marshaler:      t.marshaler,
+		unmarshaler:    t.unmarshaler,
+		traceMarshaler: t.traceMarshaler,
+		encryptor:      t.encryptor,
+		decryptor:      t.decryptor,
+		retry:          t.retry,
+		dedup:          t.dedup,
+		users:          t.users,
+	}
+}
+
+func (c *connectionSnapshot) release() {
+	if c.users != nil {
+		c.users.Done()
+	}
+}
+
+// marshal uses the wire format's trace context aware marshaler when it has one
+func (c *connectionSnapshot) marshal(ctx context.Context, envelope types.MessageEnvelope) (*message.Message, error) {
+	if c.traceMarshaler != nil {
+		return c.traceMarshaler(ctx, envelope, c.encryptor)
+	}
+	return c.marshaler(envelope, c.encryptor)
+}
+
+// Reconfigure applies an updated configuration to a running trigger.  Topic changes on the output
+// side apply to the next message published.  Subscription, concurrency, retry and dedup changes
+// restart the subscription, and connection changes (backend, broker, encryption, wire format etc.)
+// also rebuild the publisher and subscriber.  Metrics and tracing changes apply on restart.
+func (t *watermillTrigger) Reconfigure(updated WatermillConfig) error {
+	t.reloadMutex.Lock()
+	defer t.reloadMutex.Unlock()
+
+	logger := t.edgeXConfig.Logger
+
+	if t.context == nil || t.context.Err() != nil {
+		return fmt.Errorf("trigger is not running")
+	}
+
+	current := t.config()
+
+	drainTimeout := defaultDrainTimeout
+
+	if updated.DrainTimeout != "" {
+		var err error
+
+		if drainTimeout, err = time.ParseDuration(updated.DrainTimeout); err != nil {
+			return fmt.Errorf("invalid drain timeout: %s", err.Error())
+		}
+	}
+
+	if restartRequired(current, updated) {
+		logger.Warn("Metrics and Tracing changes will be applied when the service restarts")
+	}
+
+	rebuild := connectionChanged(current, updated)
+
+	if !rebuild && !subscriptionChanged(current, updated) && !processingChanged(current, updated) {
+		t.drainTimeout = drainTimeout
+		t.setConfig(updated)
+
+		logger.Info("Applied WatermillTrigger configuration update")
+
+		return nil
+	}
+
+	ordering, err := parseOrderingKey(updated.OrderingKey)
+
+	if err != nil {
+		return err
+	}
+
+	retry, err := newRetryPolicy(updated.Retry)
+
+	if err != nil {
+		return err
+	}
+
+	dedup, err := t.reconfigureDedup(current.Dedup, updated.Dedup)
+
+	if err != nil {
+		return err
+	}
+
+	// closes the store of a deduplicator the trigger is not using, whether opened for an update
+	// that is abandoned or replaced by one
+	closeUnusedDedup := func(d *deduplicator) {
+		if d != nil && (t.dedup == nil || d.store != t.dedup.store) {
+			if err := d.store.Close(); err != nil {
+				logger.Error("Unable to close dedup store", "error", err.Error())
+			}
+		}
+	}
+
+	var conn *triggerConnection
+
+	// connect before stopping anything so a bad update leaves the trigger running as it was
+	if rebuild {
+		if conn, err = t.connect(updated); err != nil {
+			closeUnusedDedup(dedup)
+			return err
+		}
+	}
+
+	logger.Info("Restarting subscription to apply WatermillTrigger configuration update")
+
+	t.stopReading()
+	t.readers.Wait()
+
+	// subscriptions stay open while draining so that in flight messages can still be acked
+	if abandoned := t.inflight.drain(drainTimeout); abandoned > 0 {
+		logger.Warn(fmt.Sprintf("Drain timed out, abandoning %d in flight message(s)", abandoned))
+	}
+
+	t.subscriptions.unsubscribe()
+
+	sub := t.sub
+
+	if conn != nil {
+		sub = conn.sub
+	}
+
+	// subscribed before the swap so a failure leaves the trigger on its current connection
+	subs, err := t.openSubscriptions(sub, updated)
+
+	if err != nil {
+		if conn != nil {
+			conn.close()
+		}
+
+		closeUnusedDedup(dedup)
+
+		t.inflight.resume()
+
+		if rollbackErr := t.subscribe(current); rollbackErr != nil {
+			logger.Error(fmt.Sprintf("Failed to restore subscription after failed update: %s", rollbackErr.Error()))
+		}
+
+		return err
+	}
+
+	if conn != nil {
+		t.swapConnection(conn, updated)
+	}
+
+	closeUnusedDedup(t.swapProcessing(retry, dedup))
+
+	t.ordering = ordering
+	t.drainTimeout = drainTimeout
+	t.setConfig(updated)
+
+	t.inflight.resume()
+
+	t.startReading(subs, updated)
+
+	return nil
+}
+
+func (t *watermillTrigger) setConfig(config WatermillConfig) {
+	t.configMutex.Lock()
+	defer t.configMutex.Unlock()
+
+	t.watermillConfig = &WatermillConfigWrapper{WatermillTrigger: config}
+}
+
+// connect builds a publisher and subscriber for config through the backend registry
+func (t *watermillTrigger) connect(config WatermillConfig) (*triggerConnection, error) {
+	backend, err := LookupBackend(config.Type)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if backend.Publisher == nil || backend.Subscriber == nil {
+		return nil, fmt.Errorf("backend '%s' does not support reconnecting triggers", config.Type)
+	}
+
+	format := ResolveWireFormat(config.WireFormat, t.edgeXConfig.Logger)
+
+	protection, err := newAESProtection(&config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := backend.Publisher(config, t.edgeXConfig.Logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := backend.Subscriber(config, t.edgeXConfig.Logger)
+
+	if err != nil {
+		_ = pub.Close()
+		return nil, err
+	}
+
+	return &triggerConnection{pub: pub, sub: sub, format: format, protection: protection}, nil
+}
+
+// reconfigureDedup builds the deduplicator for an updated configuration, keeping the current
+// store when its settings are unchanged so keys already processed are still recognised
+func (t *watermillTrigger) reconfigureDedup(current DedupConfig, updated DedupConfig) (*deduplicator, error) {
+	if t.dedup == nil || !sameDedupStore(current, updated) {
+		return newDeduplicator(updated)
+	}
+
+	d, err := parseDeduplicator(updated)
+
+	if err != nil || d == nil {
+		return nil, err
+	}
+
+	d.store = t.dedup.store
+
+	return d, nil
+}
+
+// swapProcessing replaces the retry and dedup policies used by messages received from now on,
+// returning the previous deduplicator
+func (t *watermillTrigger) swapProcessing(retry *retryPolicy, dedup *deduplicator) *deduplicator {
+	t.connMutex.Lock()
+	defer t.connMutex.Unlock()
+
+	previous := t.dedup
+
+	t.retry = retry
+	t.dedup = dedup
+
+	return previous
+}
+
+// swapConnection closes the current publisher and subscriber and replaces them.  Only safe once
+// reading has stopped and in flight messages have drained, any abandoned by the drain are waited
+// for before the old connection is closed unless the trigger is shutting down.
+func (t *watermillTrigger) swapConnection(conn *triggerConnection, config WatermillConfig) {
+	logger := t.edgeXConfig.Logger
+
+	t.connMutex.Lock()
+	defer t.connMutex.Unlock()
+
+	if t.users != nil {
+		done := make(chan struct{})
+
+		go func(users *sync.WaitGroup) {
+			users.Wait()
+			close(done)
+		}(t.users)
+
+		select {
+		case <-done:
+		case <-t.context.Done():
+			logger.Warn("Closing previous connection with messages still in flight")
+		}
+	}
+
+	if t.sub != nil {
+		if err := t.sub.Close(); err != nil {
+			logger.Error("Unable to disconnect t Subscriber", "error", err.Error())
+		}
+	}
+
+	// closed before wrapping the new publisher, a spool or outbox file can only be opened once
+	if t.pub != nil {
+		if err := t.pub.Close(); err != nil {
+			logger.Error("Unable to disconnect t Publisher", "error", err.Error())
+		}
+	}
+
+	t.health.setBackend(config.Type)
+	t.metrics.setBackend(config.Type)
+
+	pub, err := t.wrapPublisher(conn.pub, &config)
+
+	if err != nil {
+		logger.Error(fmt.Sprintf("Unable to reopen spool or outbox, publishing directly: %s", err.Error()))
+		pub = t.instrumentPublisher(conn.pub)
+	}
+
+	t.health.reconnected()
+
+	t.pub = pub
+	t.sub = conn.sub
+	t.marshaler = conn.format.Marshal
+	t.unmarshaler = conn.format.Unmarshal
+	t.traceMarshaler = traceContextMarshaler(conn.format)
+	t.encryptor = noopModifier
+	t.decryptor = noopModifier
+	t.users = &sync.WaitGroup{}
+
+	if conn.protection != nil {
+		t.encryptor = conn.protection.encrypt
+		t.decryptor = conn.protection.decrypt
+	}
+}
+
+func connectionChanged(current WatermillConfig, updated WatermillConfig) bool {
+	return !strings.EqualFold(current.Type, updated.Type) ||
+		current.BrokerUrl != updated.BrokerUrl ||
+		current.ClientId != updated.ClientId ||
+		current.ConsumerGroup != updated.ConsumerGroup ||
+		current.WireFormat != updated.WireFormat ||
+		current.EncryptionAlgorithm != updated.EncryptionAlgorithm ||
+		current.EncryptionKey != updated.EncryptionKey ||
+		current.PartitionKey != updated.PartitionKey ||
+		!reflect.DeepEqual(current.Optional, updated.Optional) ||
+		current.Spool != updated.Spool ||
+		current.Outbox != updated.Outbox
+}
+
+func subscriptionChanged(current WatermillConfig, updated WatermillConfig) bool {
+	return current.SubscribeTopics != updated.SubscribeTopics ||
+		current.MaxConcurrency != updated.MaxConcurrency ||
+		current.ConcurrencyPerTopic != updated.ConcurrencyPerTopic ||
+		current.OrderingKey != updated.OrderingKey ||
+		current.OrderingWorkers != updated.OrderingWorkers
+}
+
+func processingChanged(current WatermillConfig, updated WatermillConfig) bool {
+	return current.Retry != updated.Retry ||
+		current.Dedup != updated.Dedup
+}
+
+func sameDedupStore(current DedupConfig, updated DedupConfig) bool {
+	return strings.EqualFold(strings.TrimSpace(current.Store), strings.TrimSpace(updated.Store)) &&
+		current.Path == updated.Path &&
+		current.MaxEntries == updated.MaxEntries
+}
+
+func restartRequired(current WatermillConfig, updated WatermillConfig) bool {
+	return current.Metrics != updated.Metrics ||
+		current.Tracing != updated.Tracing
+}
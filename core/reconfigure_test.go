@@ -0,0 +1,368 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"errors"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newReconfigureTestTrigger starts a trigger reading from pubSub, reporting the payloads it receives
+func newReconfigureTestTrigger(t *testing.T, pubSub *gochannel.GoChannel, config WatermillConfig) (*watermillTrigger, <-chan string) {
+	received := make(chan string, 10)
+
+	format := &RawWireFormat{}
+
+	sut, err := NewWatermillTrigger(pubSub, pubSub, format, &WatermillConfigWrapper{WatermillTrigger: config}, interfaces.TriggerConfig{
+		Logger: logger.NewMockClient(),
+		ContextBuilder: func(env types.MessageEnvelope) interfaces.AppFunctionContext {
+			return pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient())
+		},
+		MessageReceived: func(ctx interfaces.AppFunctionContext, envelope types.MessageEnvelope, responseHandler interfaces.PipelineResponseHandler) error {
+			received <- string(envelope.Payload)
+			return nil
+		},
+	})
+
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	deferred, err := sut.Initialize(&sync.WaitGroup{}, ctx, nil)
+
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		cancel()
+		deferred()
+	})
+
+	return sut.(*watermillTrigger), received
+}
+
+func requireReceived(t *testing.T, received <-chan string, expected string) {
+	select {
+	case payload := <-received:
+		require.Equal(t, expected, payload)
+	case <-time.After(time.Second):
+		require.Fail(t, "message should be received", expected)
+	}
+}
+
+func requireNotReceived(t *testing.T, received <-chan string) {
+	select {
+	case payload := <-received:
+		require.Fail(t, "message should not be received", payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReconfigure_PublishTopic(t *testing.T) {
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	sut, received := newReconfigureTestTrigger(t, pubSub, WatermillConfig{SubscribeTopics: "in", PublishTopic: "out"})
+
+	restarted := false
+	stop := sut.stopReading
+	sut.stopReading = func() {
+		restarted = true
+		stop()
+	}
+
+	require.NoError(t, sut.Reconfigure(WatermillConfig{SubscribeTopics: "in", PublishTopic: "elsewhere", DeadLetterTopic: "dlq"}))
+
+	require.Equal(t, "elsewhere", sut.config().PublishTopic)
+	require.Equal(t, "dlq", sut.config().DeadLetterTopic)
+	require.False(t, restarted, "subscription should not restart")
+
+	require.NoError(t, pubSub.Publish("in", message.NewMessage(uuid.NewString(), []byte("{}"))))
+	requireReceived(t, received, "{}")
+}
+
+func TestReconfigure_SubscribeTopics(t *testing.T) {
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	sut, received := newReconfigureTestTrigger(t, pubSub, WatermillConfig{SubscribeTopics: "a"})
+
+	require.NoError(t, sut.Reconfigure(WatermillConfig{SubscribeTopics: "b", MaxConcurrency: 2}))
+
+	require.NoError(t, pubSub.Publish("a", message.NewMessage(uuid.NewString(), []byte(`"a"`))))
+	requireNotReceived(t, received)
+
+	require.NoError(t, pubSub.Publish("b", message.NewMessage(uuid.NewString(), []byte(`"b"`))))
+	requireReceived(t, received, `"b"`)
+}
+
+func TestReconfigure_Rebuild(t *testing.T) {
+	name := uuid.NewString()
+	replacement := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	require.NoError(t, RegisterBackend(name, Backend{
+		Publisher: func(config WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
+			return replacement, nil
+		},
+		Subscriber: func(config WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error) {
+			return replacement, nil
+		},
+	}))
+
+	original := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	sut, received := newReconfigureTestTrigger(t, original, WatermillConfig{SubscribeTopics: "in", WireFormat: RawWireFormatName})
+
+	require.NoError(t, sut.Reconfigure(WatermillConfig{Type: name, SubscribeTopics: "in", WireFormat: RawWireFormatName}))
+
+	require.Error(t, original.Publish("in", message.NewMessage(uuid.NewString(), nil)), "original connection should be closed")
+	require.Equal(t, name, sut.Health().Backend)
+
+	require.NoError(t, replacement.Publish("in", message.NewMessage(uuid.NewString(), []byte("{}"))))
+	requireReceived(t, received, "{}")
+}
+
+func TestReconfigure_InvalidBackend(t *testing.T) {
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	sut, received := newReconfigureTestTrigger(t, pubSub, WatermillConfig{SubscribeTopics: "in"})
+
+	require.Error(t, sut.Reconfigure(WatermillConfig{Type: uuid.NewString(), SubscribeTopics: "other"}))
+
+	require.Equal(t, "in", sut.config().SubscribeTopics)
+
+	require.NoError(t, pubSub.Publish("in", message.NewMessage(uuid.NewString(), []byte("{}"))))
+	requireReceived(t, received, "{}")
+}
+
+func TestReconfigure_Retry(t *testing.T) {
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	sut, _ := newReconfigureTestTrigger(t, pubSub, WatermillConfig{SubscribeTopics: "in"})
+
+	require.Error(t, sut.Reconfigure(WatermillConfig{SubscribeTopics: "in", Retry: RetryConfig{MaxAttempts: 3, Multiplier: 0.5}}))
+
+	conn := sut.acquire()
+	require.Nil(t, conn.retry, "invalid update should leave the trigger as it was")
+	conn.release()
+
+	require.NoError(t, sut.Reconfigure(WatermillConfig{SubscribeTopics: "in", Retry: RetryConfig{MaxAttempts: 3}}))
+
+	conn = sut.acquire()
+	defer conn.release()
+
+	require.NotNil(t, conn.retry)
+	require.Equal(t, 3, conn.retry.maxAttempts)
+}
+
+func TestReconfigure_Dedup(t *testing.T) {
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	sut, received := newReconfigureTestTrigger(t, pubSub, WatermillConfig{SubscribeTopics: "in"})
+
+	require.NoError(t, sut.Reconfigure(WatermillConfig{SubscribeTopics: "in", Dedup: DedupConfig{Key: DedupKeyUUID}}))
+
+	id := uuid.NewString()
+
+	require.NoError(t, pubSub.Publish("in", message.NewMessage(id, []byte("{}"))))
+	requireReceived(t, received, "{}")
+
+	store := sut.dedup.store
+
+	// the store is kept when only the ttl changes, so processed keys are still recognised
+	require.NoError(t, sut.Reconfigure(WatermillConfig{SubscribeTopics: "in", Dedup: DedupConfig{Key: DedupKeyUUID, TTL: "1h"}}))
+
+	require.Same(t, store, sut.dedup.store)
+
+	require.NoError(t, pubSub.Publish("in", message.NewMessage(id, []byte("{}"))))
+	requireNotReceived(t, received)
+}
+
+func TestReconfigure_AckBeforeUnsubscribe(t *testing.T) {
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+	committed := make(chan bool, 1)
+
+	sub := mockSubscriber{}
+	sub.On("Subscribe", mock.Anything, "a").Return(committingSubscription(msg, committed), nil)
+	sub.On("Subscribe", mock.Anything, "b").Return(make(chan *message.Message), nil)
+	sub.On("Close").Return(nil)
+
+	pub := mockPublisher{}
+	pub.On("Close").Return(nil)
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+
+	sut, err := NewWatermillTrigger(&pub, &sub, &RawWireFormat{}, &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{SubscribeTopics: "a"}}, interfaces.TriggerConfig{
+		Logger: logger.NewMockClient(),
+		ContextBuilder: func(env types.MessageEnvelope) interfaces.AppFunctionContext {
+			return pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient())
+		},
+		MessageReceived: func(ctx interfaces.AppFunctionContext, envelope types.MessageEnvelope, responseHandler interfaces.PipelineResponseHandler) error {
+			close(started)
+			<-proceed
+			return nil
+		},
+	})
+
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	deferred, err := sut.Initialize(&sync.WaitGroup{}, ctx, nil)
+
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		cancel()
+		deferred()
+	})
+
+	<-started
+
+	reconfigured := make(chan error)
+
+	go func() {
+		reconfigured <- sut.(*watermillTrigger).Reconfigure(WatermillConfig{SubscribeTopics: "b"})
+	}()
+
+	require.Eventually(t, func() bool { return draining(&sut.(*watermillTrigger).inflight) }, time.Second, time.Millisecond)
+
+	close(proceed)
+
+	require.NoError(t, <-reconfigured)
+	require.True(t, <-committed, "ack should reach the subscriber before it is unsubscribed")
+}
+
+func TestReconfigure_BackgroundHeldDuringDrain(t *testing.T) {
+	name := uuid.NewString()
+	replacement := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	require.NoError(t, RegisterBackend(name, Backend{
+		Publisher: func(config WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
+			return replacement, nil
+		},
+		Subscriber: func(config WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error) {
+			return replacement, nil
+		},
+	}))
+
+	published, err := replacement.Subscribe(context.Background(), "bg")
+
+	require.NoError(t, err)
+
+	original := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+	background := make(chan interfaces.BackgroundMessage)
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+
+	sut, err := NewWatermillTrigger(original, original, &RawWireFormat{}, &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{SubscribeTopics: "in", WireFormat: RawWireFormatName}}, interfaces.TriggerConfig{
+		Logger: logger.NewMockClient(),
+		ContextBuilder: func(env types.MessageEnvelope) interfaces.AppFunctionContext {
+			return pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient())
+		},
+		MessageReceived: func(ctx interfaces.AppFunctionContext, envelope types.MessageEnvelope, responseHandler interfaces.PipelineResponseHandler) error {
+			close(started)
+			<-proceed
+			return nil
+		},
+	})
+
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	deferred, err := sut.Initialize(&sync.WaitGroup{}, ctx, background)
+
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		cancel()
+		deferred()
+	})
+
+	require.NoError(t, original.Publish("in", message.NewMessage(uuid.NewString(), []byte("{}"))))
+
+	<-started
+
+	reconfigured := make(chan error)
+
+	go func() {
+		reconfigured <- sut.(*watermillTrigger).Reconfigure(WatermillConfig{Type: name, SubscribeTopics: "in", WireFormat: RawWireFormatName})
+	}()
+
+	require.Eventually(t, func() bool { return draining(&sut.(*watermillTrigger).inflight) }, time.Second, time.Millisecond)
+
+	background <- MockBackgroundMessage{types.MessageEnvelope{Payload: []byte("held")}, "bg"}
+
+	close(proceed)
+
+	require.NoError(t, <-reconfigured)
+
+	select {
+	case msg := <-published:
+		require.Equal(t, []byte("held"), []byte(msg.Payload))
+		msg.Ack()
+	case <-time.After(time.Second):
+		require.Fail(t, "background message should be published through the new connection")
+	}
+}
+
+func TestReconfigure_SubscribeFailureRollsBack(t *testing.T) {
+	name := uuid.NewString()
+
+	failing := mockSubscriber{}
+	failing.On("Subscribe", mock.Anything, "in").Return(nil, errors.New("subscribe failed"))
+	failing.On("Close").Return(nil)
+
+	failingPub := mockPublisher{}
+	failingPub.On("Close").Return(nil)
+
+	require.NoError(t, RegisterBackend(name, Backend{
+		Publisher: func(config WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
+			return &failingPub, nil
+		},
+		Subscriber: func(config WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error) {
+			return &failing, nil
+		},
+	}))
+
+	original := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	sut, received := newReconfigureTestTrigger(t, original, WatermillConfig{SubscribeTopics: "in", WireFormat: RawWireFormatName})
+
+	require.Error(t, sut.Reconfigure(WatermillConfig{Type: name, SubscribeTopics: "in", WireFormat: RawWireFormatName}))
+
+	require.Equal(t, "", sut.config().Type)
+	require.Equal(t, "", sut.Health().Backend)
+	failing.AssertCalled(t, "Close")
+	failingPub.AssertCalled(t, "Close")
+
+	require.NoError(t, original.Publish("in", message.NewMessage(uuid.NewString(), []byte("{}"))))
+	requireReceived(t, received, "{}")
+}
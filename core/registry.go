@@ -0,0 +1,107 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type PublisherFactory func(config WatermillConfig, lc logger.LoggingClient) (message.Publisher, error)
+
+type SubscriberFactory func(config WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error)
+
+type TriggerFactory func(wc *WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error)
+
+type ClientFactory func(ctx context.Context, config WatermillConfig, lc logger.LoggingClient) (messaging.MessageClient, error)
+
+type SenderFactory func(config WatermillConfig, proceed bool, lc logger.LoggingClient) (WatermillSender, error)
+
+// Backend holds the constructors a broker binding exposes.  Backends register themselves
+// by name so that WatermillConfig.Type can be resolved without the caller knowing about them.
+type Backend struct {
+	Publisher  PublisherFactory
+	Subscriber SubscriberFactory
+	Trigger    TriggerFactory
+	Client     ClientFactory
+	Sender     SenderFactory
+}
+
+var (
+	backendsMutex sync.RWMutex
+	backends      = make(map[string]Backend)
+)
+
+func backendKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// RegisterBackend makes a backend available under the given (case insensitive) name.
+func RegisterBackend(name string, backend Backend) error {
+	key := backendKey(name)
+
+	if key == "" {
+		return fmt.Errorf("backend name must be specified")
+	}
+
+	backendsMutex.Lock()
+	defer backendsMutex.Unlock()
+
+	if _, found := backends[key]; found {
+		return fmt.Errorf("backend already registered: %s", name)
+	}
+
+	backends[key] = backend
+
+	return nil
+}
+
+// LookupBackend returns the backend registered under the given name.
+func LookupBackend(name string) (Backend, error) {
+	backendsMutex.RLock()
+	backend, found := backends[backendKey(name)]
+	backendsMutex.RUnlock()
+
+	if !found {
+		return Backend{}, fmt.Errorf("invalid backend type specified: '%s' (registered: %s)", name, strings.Join(RegisteredBackends(), ", "))
+	}
+
+	return backend, nil
+}
+
+// RegisteredBackends returns the sorted names of all registered backends.
+func RegisteredBackends() []string {
+	backendsMutex.RLock()
+	defer backendsMutex.RUnlock()
+
+	names := make([]string, 0, len(backends))
+
+	for name := range backends {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
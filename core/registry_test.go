@@ -0,0 +1,73 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestRegisterBackend(t *testing.T) {
+	name := uuid.NewString()
+
+	pub := &mockPublisher{}
+
+	err := RegisterBackend(strings.ToUpper(name), Backend{
+		Publisher: func(config WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
+			return pub, nil
+		},
+	})
+
+	require.NoError(t, err)
+
+	backend, err := LookupBackend(name)
+
+	require.NoError(t, err)
+	require.NotNil(t, backend.Publisher)
+
+	p, err := backend.Publisher(WatermillConfig{}, logger.NewMockClient())
+
+	require.NoError(t, err)
+	require.Equal(t, pub, p)
+	require.Contains(t, RegisteredBackends(), name)
+}
+
+func TestRegisterBackend_Duplicate(t *testing.T) {
+	name := uuid.NewString()
+
+	require.NoError(t, RegisterBackend(name, Backend{}))
+	require.Error(t, RegisterBackend(name, Backend{}))
+}
+
+func TestRegisterBackend_NoName(t *testing.T) {
+	require.Error(t, RegisterBackend(" ", Backend{}))
+}
+
+func TestLookupBackend_NotRegistered(t *testing.T) {
+	registered := uuid.NewString()
+
+	require.NoError(t, RegisterBackend(registered, Backend{}))
+
+	_, err := LookupBackend(uuid.NewString())
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), registered, "should list registered backends")
+}
@@ -0,0 +1,154 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
+	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	// ReplyTopicMetadataKey names the topic a response should be published to
+	ReplyTopicMetadataKey = "edgex_reply_to"
+	// ReplyTopicContextKey exposes the reply topic of a received request to pipeline functions
+	ReplyTopicContextKey = "replytopic"
+
+	defaultReplyTopicPrefix = "edgex.reply."
+)
+
+// RequestReplyClient is a messaging client that can also wait on a response to a published message
+type RequestReplyClient interface {
+	messaging.MessageClient
+	// Request publishes the envelope to topic and blocks until a response with the same correlation
+	// ID arrives on the client's reply topic or the timeout expires.
+	Request(env types.MessageEnvelope, topic string, timeout time.Duration) (types.MessageEnvelope, error)
+}
+
+var _ RequestReplyClient = &watermillClient{}
+
+func (c *watermillClient) Request(env types.MessageEnvelope, topic string, timeout time.Duration) (types.MessageEnvelope, error) {
+	if err := c.listenForReplies(); err != nil {
+		return types.MessageEnvelope{}, err
+	}
+
+	if env.CorrelationID == "" {
+		env.CorrelationID = uuid.NewString()
+	}
+
+	replies := make(chan types.MessageEnvelope, 1)
+
+	c.pendingMutex.Lock()
+	c.pending[env.CorrelationID] = replies
+	c.pendingMutex.Unlock()
+
+	defer func() {
+		c.pendingMutex.Lock()
+		delete(c.pending, env.CorrelationID)
+		c.pendingMutex.Unlock()
+	}()
+
+	m, err := c.marshaler(env, c.encryptor)
+
+	if err != nil {
+		return types.MessageEnvelope{}, err
+	}
+
+	m.Metadata.Set(ReplyTopicMetadataKey, c.replyTopic)
+	m.Metadata.Set(middleware.CorrelationIDMetadataKey, env.CorrelationID)
+
+	if err = c.pub.Publish(topic, m); err != nil {
+		return types.MessageEnvelope{}, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-replies:
+		return reply, nil
+	case <-timer.C:
+		return types.MessageEnvelope{}, fmt.Errorf("timed out waiting for reply to %s on %s", env.CorrelationID, c.replyTopic)
+	case <-c.context.Done():
+		return types.MessageEnvelope{}, c.context.Err()
+	}
+}
+
+// listenForReplies subscribes to the client's reply topic the first time a request is made
+func (c *watermillClient) listenForReplies() error {
+	c.replyOnce.Do(func() {
+		if c.sub == nil {
+			c.replyErr = fmt.Errorf("a subscriber is required to receive replies")
+			return
+		}
+
+		replies, err := c.sub.Subscribe(c.context, c.replyTopic)
+
+		if err != nil {
+			c.replyErr = err
+			return
+		}
+
+		go func() {
+			for {
+				select {
+				case <-c.context.Done():
+					return
+				case msg, ok := <-replies:
+					if !ok {
+						return
+					}
+					c.deliverReply(msg)
+				}
+			}
+		}()
+	})
+
+	return c.replyErr
+}
+
+func (c *watermillClient) deliverReply(msg *message.Message) {
+	// nothing else is waiting on the reply topic, so there is no sense in redelivery
+	defer msg.Ack()
+
+	env, err := c.unmarshaler(msg, c.decryptor)
+
+	if err != nil {
+		return
+	}
+
+	correlationID := msg.Metadata.Get(middleware.CorrelationIDMetadataKey)
+
+	if correlationID == "" {
+		correlationID = env.CorrelationID
+	}
+
+	c.pendingMutex.Lock()
+	replies, found := c.pending[correlationID]
+	c.pendingMutex.Unlock()
+
+	if found {
+		select {
+		case replies <- env:
+		default:
+		}
+	}
+}
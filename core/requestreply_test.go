@@ -0,0 +1,124 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/ThreeDotsLabs/watermill/pubsub/gochannel"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/common"
+	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	requestTopic := uuid.NewString()
+
+	requests, err := pubSub.Subscribe(ctx, requestTopic)
+
+	require.NoError(t, err)
+
+	go func() {
+		for request := range requests {
+			reply := message.NewMessage(uuid.NewString(), []byte("pong"))
+			reply.Metadata.Set(middleware.CorrelationIDMetadataKey, request.Metadata.Get(middleware.CorrelationIDMetadataKey))
+			request.Ack()
+			_ = pubSub.Publish(request.Metadata.Get(ReplyTopicMetadataKey), reply)
+		}
+	}()
+
+	client, err := NewWatermillClient(ctx, pubSub, pubSub, &RawWireFormat{}, &WatermillConfig{ReplyTopicPrefix: "replies."})
+
+	require.NoError(t, err)
+
+	rr, ok := client.(RequestReplyClient)
+
+	require.True(t, ok, "should support request / reply")
+	require.True(t, strings.HasPrefix(client.(*watermillClient).replyTopic, "replies."))
+
+	correlationID := uuid.NewString()
+
+	reply, err := rr.Request(types.MessageEnvelope{CorrelationID: correlationID, Payload: []byte("ping"), ContentType: common.ContentTypeJSON}, requestTopic, 5*time.Second)
+
+	require.NoError(t, err)
+	require.Equal(t, correlationID, reply.CorrelationID)
+	require.Equal(t, []byte("pong"), reply.Payload)
+}
+
+func TestRequest_Timeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pubSub := gochannel.NewGoChannel(gochannel.Config{}, watermill.NopLogger{})
+
+	client, err := NewWatermillClient(ctx, pubSub, pubSub, &RawWireFormat{}, nil)
+
+	require.NoError(t, err)
+
+	_, err = client.(RequestReplyClient).Request(types.MessageEnvelope{Payload: []byte("ping")}, uuid.NewString(), 10*time.Millisecond)
+
+	require.Error(t, err)
+}
+
+func TestRequest_NoSubscriber(t *testing.T) {
+	client, err := NewWatermillClient(context.Background(), &mockPublisher{}, nil, &RawWireFormat{}, nil)
+
+	require.NoError(t, err)
+
+	_, err = client.(RequestReplyClient).Request(types.MessageEnvelope{}, uuid.NewString(), time.Second)
+
+	require.Error(t, err)
+}
+
+func TestOutput_Reply(t *testing.T) {
+	replyTopic := uuid.NewString()
+
+	ctx := pkg.NewAppFuncContextForTest(uuid.NewString(), logger.MockLogger{})
+	ctx.SetResponseData([]byte("OK"))
+	ctx.AddValue(ReplyTopicContextKey, replyTopic)
+
+	marshaled := message.NewMessage(uuid.NewString(), nil)
+
+	pub := mockPublisher{}
+	pub.On("Publish", replyTopic, marshaled).Return(nil)
+
+	marshaler := mockMarshaler{}
+	marshaler.On("Execute", mock.Anything, mock.Anything).Return(marshaled, nil)
+
+	sut := watermillTrigger{pub: &pub, marshaler: marshaler.Execute, watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{PublishTopic: uuid.NewString()}}}
+
+	err := sut.output(ctx, &interfaces.FunctionPipeline{})
+
+	require.NoError(t, err)
+	pub.AssertExpectations(t)
+	require.Equal(t, ctx.CorrelationID(), marshaled.Metadata.Get(middleware.CorrelationIDMetadataKey))
+}
@@ -0,0 +1,150 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"fmt"
+	"github.com/cenkalti/backoff/v3"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/util"
+	"strings"
+	"time"
+)
+
+const (
+	ErrorClassUnmarshal = "unmarshal"
+	ErrorClassPipeline  = "pipeline"
+
+	AttemptMetadataKey = "edgex_watermill_attempt"
+)
+
+const (
+	defaultRetryInitialInterval = 100 * time.Millisecond
+	defaultRetryMaxInterval     = 10 * time.Second
+	defaultRetryMultiplier      = 2
+	defaultRetryJitter          = 0.5
+)
+
+// processingError tags an error with the stage of message handling that produced it
+type processingError struct {
+	class string
+	err   error
+}
+
+func (pe *processingError) Error() string {
+	return fmt.Sprintf("%s failed: %s", pe.class, pe.err.Error())
+}
+
+func (pe *processingError) Unwrap() error {
+	return pe.err
+}
+
+type retryPolicy struct {
+	maxAttempts     int
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	multiplier      float64
+	jitter          float64
+	retryable       map[string]bool
+}
+
+func newRetryPolicy(cfg RetryConfig) (*retryPolicy, error) {
+	if cfg.MaxAttempts <= 0 {
+		return nil, nil
+	}
+
+	policy := &retryPolicy{
+		maxAttempts:     cfg.MaxAttempts,
+		initialInterval: defaultRetryInitialInterval,
+		maxInterval:     defaultRetryMaxInterval,
+		multiplier:      defaultRetryMultiplier,
+		jitter:          defaultRetryJitter,
+		retryable:       make(map[string]bool),
+	}
+
+	var err error
+
+	if cfg.InitialInterval != "" {
+		if policy.initialInterval, err = time.ParseDuration(cfg.InitialInterval); err != nil {
+			return nil, fmt.Errorf("invalid retry initial interval: %s", err.Error())
+		}
+	}
+
+	if cfg.MaxInterval != "" {
+		if policy.maxInterval, err = time.ParseDuration(cfg.MaxInterval); err != nil {
+			return nil, fmt.Errorf("invalid retry max interval: %s", err.Error())
+		}
+	}
+
+	if cfg.Multiplier != 0 {
+		if cfg.Multiplier < 1 {
+			return nil, fmt.Errorf("invalid retry multiplier: %f (must be at least 1)", cfg.Multiplier)
+		}
+		policy.multiplier = cfg.Multiplier
+	}
+
+	if cfg.Jitter != 0 {
+		if cfg.Jitter < 0 || cfg.Jitter > 1 {
+			return nil, fmt.Errorf("invalid retry jitter: %f (must be between 0 and 1)", cfg.Jitter)
+		}
+		policy.jitter = cfg.Jitter
+	}
+
+	classes := util.DeleteEmptyAndTrim(strings.FieldsFunc(cfg.RetryOn, util.SplitComma))
+
+	if len(classes) == 0 {
+		classes = []string{ErrorClassUnmarshal, ErrorClassPipeline}
+	}
+
+	for _, class := range classes {
+		switch c := strings.ToLower(class); c {
+		case ErrorClassUnmarshal, ErrorClassPipeline:
+			policy.retryable[c] = true
+		default:
+			return nil, fmt.Errorf("invalid retryable error class: %s", class)
+		}
+	}
+
+	return policy, nil
+}
+
+// shouldRetry reports whether a message that failed with the given error on the given attempt
+// (starting from 1) should be processed again
+func (rp *retryPolicy) shouldRetry(err error, attempt int) bool {
+	if attempt >= rp.maxAttempts {
+		return false
+	}
+
+	if pe, ok := err.(*processingError); ok {
+		return rp.retryable[pe.class]
+	}
+
+	return false
+}
+
+func (rp *retryPolicy) newBackOff() backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+
+	bo.InitialInterval = rp.initialInterval
+	bo.MaxInterval = rp.maxInterval
+	bo.Multiplier = rp.multiplier
+	bo.RandomizationFactor = rp.jitter
+	bo.MaxElapsedTime = 0
+
+	bo.Reset()
+
+	return bo
+}
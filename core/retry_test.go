@@ -0,0 +1,88 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewRetryPolicy_Disabled(t *testing.T) {
+	policy, err := newRetryPolicy(RetryConfig{})
+
+	require.NoError(t, err)
+	require.Nil(t, policy)
+}
+
+func TestNewRetryPolicy_Defaults(t *testing.T) {
+	policy, err := newRetryPolicy(RetryConfig{MaxAttempts: 3})
+
+	require.NoError(t, err)
+	require.Equal(t, defaultRetryInitialInterval, policy.initialInterval)
+	require.Equal(t, defaultRetryMaxInterval, policy.maxInterval)
+	require.True(t, policy.retryable[ErrorClassUnmarshal])
+	require.True(t, policy.retryable[ErrorClassPipeline])
+}
+
+func TestNewRetryPolicy_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  RetryConfig
+	}{
+		{"InitialInterval", RetryConfig{MaxAttempts: 1, InitialInterval: "soon"}},
+		{"MaxInterval", RetryConfig{MaxAttempts: 1, MaxInterval: "later"}},
+		{"Jitter", RetryConfig{MaxAttempts: 1, Jitter: 2}},
+		{"Multiplier", RetryConfig{MaxAttempts: 1, Multiplier: 0.5}},
+		{"NegativeMultiplier", RetryConfig{MaxAttempts: 1, Multiplier: -2}},
+		{"RetryOn", RetryConfig{MaxAttempts: 1, RetryOn: "pipeline,everything"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newRetryPolicy(tt.cfg)
+
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestRetryPolicy_ShouldRetry(t *testing.T) {
+	policy, err := newRetryPolicy(RetryConfig{MaxAttempts: 2, RetryOn: "pipeline"})
+
+	require.NoError(t, err)
+
+	pipelineErr := &processingError{class: ErrorClassPipeline, err: errors.New("")}
+
+	require.True(t, policy.shouldRetry(pipelineErr, 1))
+	require.False(t, policy.shouldRetry(pipelineErr, 2), "attempts exhausted")
+	require.False(t, policy.shouldRetry(&processingError{class: ErrorClassUnmarshal, err: errors.New("")}, 1), "class not retryable")
+	require.False(t, policy.shouldRetry(errors.New(""), 1), "unclassified errors not retryable")
+}
+
+func TestRetryPolicy_BackOff(t *testing.T) {
+	policy, err := newRetryPolicy(RetryConfig{MaxAttempts: 5, InitialInterval: "10ms", MaxInterval: "25ms", Multiplier: 2, Jitter: 0.01})
+
+	require.NoError(t, err)
+
+	bo := policy.newBackOff()
+
+	require.InDelta(t, 10*time.Millisecond, bo.NextBackOff(), float64(time.Millisecond))
+	require.InDelta(t, 20*time.Millisecond, bo.NextBackOff(), float64(time.Millisecond))
+	require.InDelta(t, 25*time.Millisecond, bo.NextBackOff(), float64(time.Millisecond))
+}
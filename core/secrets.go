@@ -0,0 +1,101 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+)
+
+// Keys read from the secret store by backends authenticating with their broker, matching those
+// used by the SDK's MQTT sender
+const (
+	SecretUsername   = "username"
+	SecretPassword   = "password"
+	SecretCACert     = "cacert"
+	SecretClientCert = "clientcert"
+	SecretClientKey  = "clientkey"
+)
+
+// SecretProvider reads credentials from the EdgeX secret store, it is satisfied by the SDK's
+// ApplicationService
+type SecretProvider interface {
+	GetSecret(path string, keys ...string) (map[string]string, error)
+}
+
+var (
+	secretMutex    sync.RWMutex
+	secretProvider SecretProvider
+)
+
+// SetSecretProvider makes the secret store available to backends, Register sets the application
+// service as the provider
+func SetSecretProvider(provider SecretProvider) {
+	secretMutex.Lock()
+	defer secretMutex.Unlock()
+
+	secretProvider = provider
+}
+
+// GetSecret reads secrets from path through the configured SecretProvider
+func GetSecret(path string, keys ...string) (map[string]string, error) {
+	secretMutex.RLock()
+	provider := secretProvider
+	secretMutex.RUnlock()
+
+	if provider == nil {
+		return nil, fmt.Errorf("no secret provider available to read '%s'", path)
+	}
+
+	secrets, err := provider.GetSecret(path, keys...)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets from '%s': %s", path, err.Error())
+	}
+
+	return secrets, nil
+}
+
+// NewTLSConfig builds client TLS settings from PEM encoded certificates, eg. read from the secret
+// store.  The system roots are used when no CA certificate is given.
+func NewTLSConfig(caCert string, clientCert string, clientKey string, skipVerify bool) (*tls.Config, error) {
+	tc := &tls.Config{
+		InsecureSkipVerify: skipVerify,
+	}
+
+	if caCert != "" {
+		tc.RootCAs = x509.NewCertPool()
+
+		if !tc.RootCAs.AppendCertsFromPEM([]byte(caCert)) {
+			return nil, fmt.Errorf("invalid %s secret, no PEM certificates found", SecretCACert)
+		}
+	}
+
+	if clientCert != "" || clientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(clientCert), []byte(clientKey))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %s", err.Error())
+		}
+
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
@@ -0,0 +1,292 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/hashicorp/go-multierror"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSpoolMaxMessages   = 10000
+	defaultSpoolRetryInterval = 5 * time.Second
+
+	spoolBucket           = "spool"
+	spoolDeadLetterBucket = "spool-dead-letter"
+)
+
+// SpoolDepthReporter is implemented by spooling publishers, the decorators that may wrap one, and
+// the triggers, clients and senders built on them, reporting the number of messages spooled
+type SpoolDepthReporter interface {
+	SpoolDepth() int
+}
+
+// spoolDepth reports the depth of any spool behind pub, zero if there is none
+func spoolDepth(pub message.Publisher) int {
+	if reporter, ok := pub.(SpoolDepthReporter); ok {
+		return reporter.SpoolDepth()
+	}
+
+	return 0
+}
+
+// SpoolingPublisher decorates a publisher, writing messages that fail to publish to a local
+// on-disk queue and replaying them in order once the broker can be reached again.
+type SpoolingPublisher struct {
+	pub         message.Publisher
+	queue       *diskQueue
+	logger      watermill.LoggerAdapter
+	interval    time.Duration
+	maxAttempts int
+	mutex       sync.Mutex
+	gauge       func()
+	closing     chan struct{}
+	closed      chan struct{}
+	closeOnce   sync.Once
+	closeErr    error
+
+	// failures of the message at the head of the spool, only touched by the replay loop
+	head     []byte
+	attempts int
+}
+
+func NewSpoolingPublisher(pub message.Publisher, config SpoolConfig, logger watermill.LoggerAdapter) (*SpoolingPublisher, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("spool path must be specified")
+	}
+
+	if logger == nil {
+		logger = watermill.NopLogger{}
+	}
+
+	max := config.MaxMessages
+
+	if max <= 0 {
+		max = defaultSpoolMaxMessages
+	}
+
+	interval := defaultSpoolRetryInterval
+
+	if config.RetryInterval != "" {
+		var err error
+
+		if interval, err = time.ParseDuration(config.RetryInterval); err != nil {
+			return nil, fmt.Errorf("invalid spool retry interval: %s", err.Error())
+		}
+	}
+
+	queue, err := openDiskQueue(config.Path, spoolBucket, max)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &SpoolingPublisher{
+		pub:         pub,
+		queue:       queue,
+		logger:      logger.With(watermill.LogFields{"spool": config.Path}),
+		interval:    interval,
+		maxAttempts: config.MaxAttempts,
+		closing:     make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+
+	sp.gauge = depthGauge("spool_depth", "Messages waiting in a spool for the broker.", config.Path, sp.Depth)
+
+	go sp.forward()
+
+	return sp, nil
+}
+
+func (sp *SpoolingPublisher) Publish(topic string, messages ...*message.Message) error {
+	// once anything is spooled everything is, until the spool drains, to keep publish order
+	if sp.empty() {
+		err := sp.pub.Publish(topic, messages...)
+
+		if err == nil {
+			return nil
+		}
+
+		sp.logger.Error("Publish failed, spooling messages", err, watermill.LogFields{"topic": topic})
+	}
+
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	if err := sp.queue.push(topic, messages...); err != nil {
+		return fmt.Errorf("failed to spool messages for %s: %s", topic, err.Error())
+	}
+
+	return nil
+}
+
+func (sp *SpoolingPublisher) empty() bool {
+	sp.mutex.Lock()
+	defer sp.mutex.Unlock()
+
+	return sp.queue.depth() == 0
+}
+
+// Depth returns the number of messages waiting in the spool
+func (sp *SpoolingPublisher) Depth() int {
+	return sp.queue.depth()
+}
+
+// SpoolDepth implements SpoolDepthReporter
+func (sp *SpoolingPublisher) SpoolDepth() int {
+	return sp.Depth()
+}
+
+// Close stops replaying and closes the decorated publisher and spool file.  Closing more than
+// once returns the result of the first close.
+func (sp *SpoolingPublisher) Close() error {
+	sp.closeOnce.Do(func() {
+		sp.gauge()
+
+		close(sp.closing)
+		<-sp.closed
+
+		if err := sp.pub.Close(); err != nil {
+			sp.closeErr = multierror.Append(sp.closeErr, err)
+		}
+
+		if err := sp.queue.close(); err != nil {
+			sp.closeErr = multierror.Append(sp.closeErr, err)
+		}
+	})
+
+	return sp.closeErr
+}
+
+func (sp *SpoolingPublisher) forward() {
+	defer close(sp.closed)
+
+	ticker := time.NewTicker(sp.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sp.closing:
+			return
+		case <-ticker.C:
+			if depth := sp.queue.depth(); depth > 0 {
+				sp.logger.Info("Replaying spooled messages", watermill.LogFields{"depth": depth})
+
+				sp.replay()
+
+				sp.logger.Info("Spool replay finished", watermill.LogFields{"depth": sp.queue.depth()})
+			}
+		}
+	}
+}
+
+// replay publishes spooled messages oldest first, stopping at the first failure
+func (sp *SpoolingPublisher) replay() {
+	for {
+		select {
+		case <-sp.closing:
+			return
+		default:
+		}
+
+		if !sp.replayNext() {
+			return
+		}
+	}
+}
+
+func (sp *SpoolingPublisher) replayNext() bool {
+	sp.mutex.Lock()
+	key, topic, msg, err := sp.queue.peek()
+	sp.mutex.Unlock()
+
+	if err != nil {
+		sp.logger.Error("Failed to read from spool", err, nil)
+		return false
+	}
+
+	if key == nil {
+		return false
+	}
+
+	// publishes arriving meanwhile see a non-empty spool and queue behind this message
+	if err = sp.pub.Publish(topic, msg); err != nil {
+		return sp.replayFailed(key, topic, msg, err)
+	}
+
+	sp.mutex.Lock()
+	err = sp.queue.remove(key)
+	sp.mutex.Unlock()
+
+	if err != nil {
+		sp.logger.Error("Failed to remove replayed message from spool", err, watermill.LogFields{"topic": topic})
+		return false
+	}
+
+	return true
+}
+
+// replayFailed counts a failed replay of the head message, moving it to the dead letter bucket
+// once it has used up MaxAttempts so a message the broker will never accept can't block the
+// spool.  It returns true if replay can carry on with the next message.
+func (sp *SpoolingPublisher) replayFailed(key []byte, topic string, msg *message.Message, err error) bool {
+	if !bytes.Equal(key, sp.head) {
+		sp.head = key
+		sp.attempts = 0
+	}
+
+	sp.attempts++
+
+	if sp.maxAttempts <= 0 || sp.attempts < sp.maxAttempts {
+		sp.logger.Debug("Broker still unavailable", watermill.LogFields{"topic": topic, "error": err.Error()})
+		return false
+	}
+
+	sp.logger.Error("Spooled message failed too many times, moving to dead letter bucket", err, watermill.LogFields{
+		"topic":    topic,
+		"uuid":     msg.UUID,
+		"attempts": sp.attempts,
+	})
+
+	sp.mutex.Lock()
+	err = sp.queue.move(key, spoolDeadLetterBucket)
+	sp.mutex.Unlock()
+
+	if err != nil {
+		sp.logger.Error("Failed to move spooled message to dead letter bucket", err, watermill.LogFields{"topic": topic})
+		return false
+	}
+
+	sp.head = nil
+	sp.attempts = 0
+
+	return true
+}
+
+// spoolPublisher wraps pub with a SpoolingPublisher when a spool is configured
+func spoolPublisher(pub message.Publisher, config *WatermillConfig, logger watermill.LoggerAdapter) (message.Publisher, error) {
+	if pub == nil || config == nil || config.Spool.Path == "" {
+		return pub, nil
+	}
+
+	return NewSpoolingPublisher(pub, config.Spool, logger)
+}
@@ -0,0 +1,214 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"errors"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyPublisher records published messages, failing while down is set
+type flakyPublisher struct {
+	mutex     sync.Mutex
+	down      bool
+	published []*message.Message
+	topics    []string
+}
+
+func (fp *flakyPublisher) Publish(topic string, messages ...*message.Message) error {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	if fp.down {
+		return errors.New("broker unavailable")
+	}
+
+	for _, m := range messages {
+		fp.published = append(fp.published, m)
+		fp.topics = append(fp.topics, topic)
+	}
+
+	return nil
+}
+
+func (fp *flakyPublisher) Close() error {
+	return nil
+}
+
+func (fp *flakyPublisher) setDown(down bool) {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+	fp.down = down
+}
+
+func (fp *flakyPublisher) publishedUUIDs() []string {
+	fp.mutex.Lock()
+	defer fp.mutex.Unlock()
+
+	ids := make([]string, len(fp.published))
+	for i, m := range fp.published {
+		ids[i] = m.UUID
+	}
+	return ids
+}
+
+func TestSpoolingPublisher_PublishDirect(t *testing.T) {
+	pub := &flakyPublisher{}
+
+	sut, err := NewSpoolingPublisher(pub, SpoolConfig{Path: filepath.Join(t.TempDir(), "spool.db")}, nil)
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	msg := message.NewMessage(uuid.NewString(), []byte("OK"))
+
+	require.NoError(t, sut.Publish("topic", msg))
+	require.Equal(t, []string{msg.UUID}, pub.publishedUUIDs())
+	require.Equal(t, 0, sut.Depth())
+}
+
+func TestSpoolingPublisher_SpoolAndReplay(t *testing.T) {
+	pub := &flakyPublisher{down: true}
+
+	sut, err := NewSpoolingPublisher(pub, SpoolConfig{Path: filepath.Join(t.TempDir(), "spool.db"), RetryInterval: "5ms"}, nil)
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	first := message.NewMessage(uuid.NewString(), []byte("first"))
+	first.Metadata.Set("key", "value")
+
+	require.NoError(t, sut.Publish("a", first))
+	require.Equal(t, 1, sut.Depth())
+
+	pub.setDown(false)
+
+	// spooled messages must go out first, so later publishes queue behind them
+	second := message.NewMessage(uuid.NewString(), []byte("second"))
+
+	require.NoError(t, sut.Publish("b", second))
+
+	require.Eventually(t, func() bool {
+		return sut.Depth() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	require.Equal(t, []string{first.UUID, second.UUID}, pub.publishedUUIDs())
+	require.Equal(t, []string{"a", "b"}, pub.topics)
+	require.Equal(t, []byte("first"), []byte(pub.published[0].Payload))
+	require.Equal(t, "value", pub.published[0].Metadata.Get("key"))
+}
+
+func TestSpoolingPublisher_Full(t *testing.T) {
+	pub := &flakyPublisher{down: true}
+
+	sut, err := NewSpoolingPublisher(pub, SpoolConfig{Path: filepath.Join(t.TempDir(), "spool.db"), MaxMessages: 1, RetryInterval: "1h"}, nil)
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	require.NoError(t, sut.Publish("topic", message.NewMessage(uuid.NewString(), nil)))
+	require.Error(t, sut.Publish("topic", message.NewMessage(uuid.NewString(), nil)))
+}
+
+func TestSpoolingPublisher_SurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spool.db")
+
+	pub := &flakyPublisher{down: true}
+
+	sut, err := NewSpoolingPublisher(pub, SpoolConfig{Path: path, RetryInterval: "1h"}, nil)
+
+	require.NoError(t, err)
+	require.NoError(t, sut.Publish("topic", message.NewMessage(uuid.NewString(), nil)))
+	require.NoError(t, sut.Close())
+
+	sut, err = NewSpoolingPublisher(pub, SpoolConfig{Path: path, RetryInterval: "1h"}, nil)
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	require.Equal(t, 1, sut.Depth())
+}
+
+func TestSpoolingPublisher_MaxAttempts(t *testing.T) {
+	pub := &flakyPublisher{down: true}
+
+	path := filepath.Join(t.TempDir(), "spool.db")
+
+	sut, err := NewSpoolingPublisher(pub, SpoolConfig{Path: path, RetryInterval: "5ms", MaxAttempts: 2}, nil)
+
+	require.NoError(t, err)
+
+	require.NoError(t, sut.Publish("topic", message.NewMessage(uuid.NewString(), nil)))
+
+	// the broker keeps rejecting the head message, so it is set aside rather than blocking the spool
+	require.Eventually(t, func() bool {
+		return sut.Depth() == 0
+	}, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, sut.Close())
+
+	queue, err := openDiskQueue(path, spoolDeadLetterBucket, 0)
+
+	require.NoError(t, err)
+	defer queue.close()
+
+	require.Equal(t, 1, queue.depth())
+	require.Empty(t, pub.publishedUUIDs())
+}
+
+func TestSpoolingPublisher_CloseTwice(t *testing.T) {
+	sut, err := NewSpoolingPublisher(&flakyPublisher{}, SpoolConfig{Path: filepath.Join(t.TempDir(), "spool.db")}, nil)
+
+	require.NoError(t, err)
+
+	require.NoError(t, sut.Close())
+	require.NoError(t, sut.Close())
+}
+
+func TestNewSpoolingPublisher_InvalidInterval(t *testing.T) {
+	_, err := NewSpoolingPublisher(&flakyPublisher{}, SpoolConfig{Path: filepath.Join(t.TempDir(), "spool.db"), RetryInterval: "often"}, nil)
+
+	require.Error(t, err)
+}
+
+func TestSpoolDepth_Decorated(t *testing.T) {
+	pub := &flakyPublisher{down: true}
+
+	spool, err := NewSpoolingPublisher(trackPublisher(pub, newHealthTracker("test")), SpoolConfig{Path: filepath.Join(t.TempDir(), "spool.db"), RetryInterval: "1h"}, nil)
+
+	require.NoError(t, err)
+
+	sut, err := NewOutbox(instrumentPublisher(spool, &metrics{backend: "test"}), OutboxConfig{Path: filepath.Join(t.TempDir(), "outbox.db"), RelayInterval: "5ms"}, nil)
+
+	require.NoError(t, err)
+	defer sut.Close()
+
+	require.NoError(t, sut.Publish("topic", message.NewMessage(uuid.NewString(), nil)))
+
+	// relayed from the outbox into the spool while the broker is down
+	require.Eventually(t, func() bool {
+		return spoolDepth(sut) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	require.Equal(t, 0, spoolDepth(pub))
+}
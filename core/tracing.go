@@ -0,0 +1,209 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+// W3C trace context keys, used for both watermill metadata and AppFunctionContext values
+const (
+	TraceParentKey = "traceparent"
+	TraceStateKey  = "tracestate"
+)
+
+const (
+	TracingExporterNone   = "none"
+	TracingExporterStdout = "stdout"
+	TracingExporterOTLP   = "otlp"
+
+	defaultTracingServiceName = "edgex-watermill"
+	tracerName                = "github.com/alexcuse/edgex-watermill"
+)
+
+var tracePropagator = propagation.TraceContext{}
+
+// TraceContextWireFormat is implemented by wire formats that carry W3C trace context in
+// message metadata.  Formats that do not implement it are marshaled without trace context.
+type TraceContextWireFormat interface {
+	MarshalContext(ctx context.Context, envelope types.MessageEnvelope, encrypt BinaryModifier) (*message.Message, error)
+}
+
+// TraceContextMarshaler matches TraceContextWireFormat.MarshalContext
+type TraceContextMarshaler func(ctx context.Context, envelope types.MessageEnvelope, encrypt BinaryModifier) (*message.Message, error)
+
+// traceContextMarshaler returns the context aware marshaler for format, if it has one
+func traceContextMarshaler(format WireFormat) TraceContextMarshaler {
+	if tf, ok := format.(TraceContextWireFormat); ok {
+		return tf.MarshalContext
+	}
+	return nil
+}
+
+type metadataCarrier message.Metadata
+
+func (mc metadataCarrier) Get(key string) string {
+	return message.Metadata(mc).Get(key)
+}
+
+func (mc metadataCarrier) Set(key string, value string) {
+	message.Metadata(mc).Set(key, value)
+}
+
+func (mc metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(mc))
+	for k := range mc {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+type appContextCarrier struct {
+	ctx interfaces.AppFunctionContext
+}
+
+func (ac appContextCarrier) Get(key string) string {
+	value, _ := ac.ctx.GetValue(key)
+	return value
+}
+
+func (ac appContextCarrier) Set(key string, value string) {
+	ac.ctx.AddValue(key, value)
+}
+
+func (ac appContextCarrier) Keys() []string {
+	return []string{TraceParentKey, TraceStateKey}
+}
+
+// InjectTraceContext writes the span context held by ctx to the message metadata
+func InjectTraceContext(ctx context.Context, msg *message.Message) {
+	tracePropagator.Inject(ctx, metadataCarrier(msg.Metadata))
+}
+
+// ExtractTraceContext reads trace context from the message metadata, making it available
+// from msg.Context()
+func ExtractTraceContext(msg *message.Message) {
+	msg.SetContext(tracePropagator.Extract(msg.Context(), metadataCarrier(msg.Metadata)))
+}
+
+// pipelineTraceContext returns a context holding the span that a pipeline is running under
+func pipelineTraceContext(ctx interfaces.AppFunctionContext) context.Context {
+	return tracePropagator.Extract(context.Background(), appContextCarrier{ctx: ctx})
+}
+
+// tracing starts spans for a trigger.  A nil *tracing starts non-recording spans that still
+// carry any incoming trace context through to published messages.
+type tracing struct {
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+func newTracing(config TracingConfig) (*tracing, error) {
+	if config.SampleRatio < 0 || config.SampleRatio > 1 {
+		return nil, fmt.Errorf("invalid tracing sample ratio: %f (must be between 0 and 1)", config.SampleRatio)
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch strings.ToLower(config.Exporter) {
+	case "", TracingExporterNone:
+		return nil, nil
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case TracingExporterOTLP:
+		opts := []otlptracehttp.Option{}
+
+		if config.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(config.Endpoint))
+		}
+
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter specified: %s", config.Exporter)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	ratio := config.SampleRatio
+
+	if ratio == 0 {
+		ratio = 1
+	}
+
+	serviceName := config.ServiceName
+
+	if serviceName == "" {
+		serviceName = defaultTracingServiceName
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+
+	return &tracing{
+		provider: provider,
+		tracer:   provider.Tracer(tracerName),
+	}, nil
+}
+
+func (tr *tracing) start(ctx context.Context, name string, kind trace.SpanKind) (context.Context, trace.Span) {
+	tracer := trace.NewNoopTracerProvider().Tracer(tracerName)
+
+	if tr != nil {
+		tracer = tr.tracer
+	}
+
+	return tracer.Start(ctx, name, trace.WithSpanKind(kind))
+}
+
+// endSpan records err (if any) against the span before ending it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (tr *tracing) shutdown(ctx context.Context) error {
+	if tr == nil {
+		return nil
+	}
+	return tr.provider.Shutdown(ctx)
+}
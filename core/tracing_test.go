@@ -0,0 +1,174 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/common"
+	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+const testTraceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func testSpanContext(t *testing.T) context.Context {
+	msg := message.NewMessage(uuid.NewString(), nil)
+	msg.Metadata.Set(TraceParentKey, testTraceParent)
+
+	ExtractTraceContext(msg)
+
+	require.True(t, trace.SpanContextFromContext(msg.Context()).IsValid())
+
+	return msg.Context()
+}
+
+func TestWireFormat_TraceContext(t *testing.T) {
+	tests := []struct {
+		name   string
+		format WireFormat
+	}{
+		{"Raw", &RawWireFormat{}},
+		{"EdgeX", &EdgeXWireFormat{}},
+		{"RawInput", &RawInputWireFormat{}},
+		{"RawOutput", &RawOutputWireFormat{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			marshaler := traceContextMarshaler(tt.format)
+
+			require.NotNil(t, marshaler)
+
+			msg, err := marshaler(testSpanContext(t), types.MessageEnvelope{
+				CorrelationID: uuid.NewString(),
+				Payload:       []byte("{}"),
+				ContentType:   common.ContentTypeJSON,
+			}, nil)
+
+			require.NoError(t, err)
+			require.Equal(t, testTraceParent, msg.Metadata.Get(TraceParentKey))
+
+			received := message.NewMessage(msg.UUID, msg.Payload)
+			received.Metadata = msg.Metadata
+
+			_, err = tt.format.Unmarshal(received, nil)
+
+			require.NoError(t, err)
+
+			sc := trace.SpanContextFromContext(received.Context())
+
+			require.True(t, sc.IsRemote())
+			require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+		})
+	}
+}
+
+func TestWireFormat_NoTraceContext(t *testing.T) {
+	msg, err := (&RawWireFormat{}).MarshalContext(context.Background(), types.MessageEnvelope{Payload: []byte("{}")}, nil)
+
+	require.NoError(t, err)
+	require.Empty(t, msg.Metadata.Get(TraceParentKey))
+
+	_, err = (&RawWireFormat{}).Unmarshal(msg, nil)
+
+	require.NoError(t, err)
+	require.False(t, trace.SpanContextFromContext(msg.Context()).IsValid())
+}
+
+func TestProcess_TraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	pub := &flakyPublisher{}
+	format := &RawWireFormat{}
+
+	sut := &watermillTrigger{
+		pub:             pub,
+		marshaler:       format.Marshal,
+		unmarshaler:     format.Unmarshal,
+		traceMarshaler:  traceContextMarshaler(format),
+		tracing:         &tracing{provider: provider, tracer: provider.Tracer(tracerName)},
+		watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{PublishTopic: "out"}},
+		edgeXConfig: interfaces.TriggerConfig{
+			Logger: logger.NewMockClient(),
+			ContextBuilder: func(env types.MessageEnvelope) interfaces.AppFunctionContext {
+				return pkg.NewAppFuncContextForTest(env.CorrelationID, logger.NewMockClient())
+			},
+			MessageReceived: func(ctx interfaces.AppFunctionContext, envelope types.MessageEnvelope, responseHandler interfaces.PipelineResponseHandler) error {
+				ctx.SetResponseData(envelope.Payload)
+				return responseHandler(ctx, &interfaces.FunctionPipeline{})
+			},
+		},
+	}
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+	msg.Metadata.Set(TraceParentKey, testTraceParent)
+
+	require.NoError(t, sut.process(sut.acquire(), msg, "in"))
+
+	require.Equal(t, 1, len(pub.published))
+
+	published := trace.SpanContextFromContext(tracePropagator.Extract(context.Background(), metadataCarrier(pub.published[0].Metadata)))
+
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", published.TraceID().String())
+
+	spans := recorder.Ended()
+
+	require.Equal(t, 3, len(spans))
+
+	names := make(map[string]sdktrace.ReadOnlySpan)
+	for _, s := range spans {
+		require.Equal(t, published.TraceID(), s.SpanContext().TraceID())
+		names[s.Name()] = s
+	}
+
+	require.Contains(t, names, "receive in")
+	require.Contains(t, names, "pipeline in")
+	require.Contains(t, names, "publish out")
+	require.Equal(t, names["publish out"].SpanContext().SpanID(), published.SpanID(), "published message should carry the publish span")
+	require.Equal(t, names["pipeline in"].SpanContext().SpanID(), names["publish out"].Parent().SpanID())
+}
+
+func TestNewTracing(t *testing.T) {
+	tr, err := newTracing(TracingConfig{})
+
+	require.NoError(t, err)
+	require.Nil(t, tr)
+
+	tr, err = newTracing(TracingConfig{Exporter: TracingExporterStdout, SampleRatio: 0.5})
+
+	require.NoError(t, err)
+	require.NotNil(t, tr)
+	require.NoError(t, tr.shutdown(context.Background()))
+
+	_, err = newTracing(TracingConfig{Exporter: uuid.NewString()})
+
+	require.Error(t, err)
+
+	_, err = newTracing(TracingConfig{Exporter: TracingExporterStdout, SampleRatio: 2})
+
+	require.Error(t, err)
+}
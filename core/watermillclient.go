@@ -19,9 +19,12 @@ package core
 import (
 	"context"
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
 	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
 	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"github.com/google/uuid"
 	"github.com/hashicorp/go-multierror"
+	"sync"
 )
 
 type watermillClient struct {
@@ -30,8 +33,17 @@ type watermillClient struct {
 	context     context.Context
 	marshaler   WatermillMarshaler
 	unmarshaler WatermillUnmarshaler
-	decryptor   binaryModifier
-	encryptor   binaryModifier
+	decryptor   BinaryModifier
+	encryptor   BinaryModifier
+	metrics     *metrics
+	health      *healthTracker
+	clientId    string
+
+	replyTopic   string
+	replyOnce    sync.Once
+	replyErr     error
+	pendingMutex sync.Mutex
+	pending      map[string]chan types.MessageEnvelope
 }
 
 const (
@@ -39,10 +51,20 @@ const (
 	EdgeXChecksum    = "edgex_checksum"
 )
 
+// Connect starts reporting the client's connection health, the backend has already connected
+// by the time the client is built
 func (c *watermillClient) Connect() error {
+	c.health.register("client", c.clientId)
+	c.health.connected()
+
 	return nil
 }
 
+// Health reports the state of the client's broker connection
+func (c *watermillClient) Health() HealthStatus {
+	return c.health.Health()
+}
+
 func (c *watermillClient) Publish(env types.MessageEnvelope, topic string) error {
 	m, err := c.marshaler(env, c.encryptor)
 
@@ -64,6 +86,7 @@ func (c *watermillClient) Subscribe(topics []types.TopicChannel, messageErrors c
 			sub, err := s.Subscribe(ctx, topic.Topic)
 
 			if err != nil {
+				c.health.disconnected(err)
 				errors <- err
 				return
 			}
@@ -72,9 +95,13 @@ func (c *watermillClient) Subscribe(topics []types.TopicChannel, messageErrors c
 				case <-ctx.Done():
 					return
 				case msg := <-sub:
+					c.metrics.received(topic.Topic)
+					c.health.received()
+
 					formattedMessage, err := c.unmarshaler(msg, c.decryptor)
 
 					if err != nil {
+						c.metrics.unmarshalFailed(topic.Topic)
 						//TODO: can we get message errors from watermill Subscriber as well?  May need to wire in differently
 						errors <- err
 					} else {
@@ -95,23 +122,38 @@ func (c *watermillClient) Disconnect() error {
 	result = multierror.Append(result, c.pub.Close())
 	result = multierror.Append(result, c.sub.Close())
 
+	c.health.disconnected(nil)
+	c.health.close()
+
 	return result
 }
 
+// SpoolDepth reports the number of messages waiting in the client's spool
+func (c *watermillClient) SpoolDepth() int {
+	return spoolDepth(c.pub)
+}
+
 func NewWatermillClient(ctx context.Context, pub message.Publisher, sub message.Subscriber, format WireFormat, watermillConfig *WatermillConfig) (messaging.MessageClient, error) {
+	return NewWatermillClientWithLogger(ctx, pub, sub, format, watermillConfig, nil)
+}
+
+// NewWatermillClientWithLogger creates a client that reports spool activity through lc
+func NewWatermillClientWithLogger(ctx context.Context, pub message.Publisher, sub message.Subscriber, format WireFormat, watermillConfig *WatermillConfig, lc logger.LoggingClient) (messaging.MessageClient, error) {
 	if format == nil {
 		format = &EdgeXWireFormat{}
 	}
 
 	return newWatermillClientWithOptions(ctx, pub, sub, WatermillClientOptions{
-		Marshaler:   format.marshal,
-		Unmarshaler: format.unmarshal,
+		Marshaler:   format.Marshal,
+		Unmarshaler: format.Unmarshal,
+		Logger:      lc,
 	}, watermillConfig)
 }
 
 type WatermillClientOptions struct {
 	Marshaler   WatermillMarshaler
 	Unmarshaler WatermillUnmarshaler
+	Logger      logger.LoggingClient
 }
 
 func newWatermillClientWithOptions(ctx context.Context, pub message.Publisher, sub message.Subscriber, opt WatermillClientOptions, config *WatermillConfig) (messaging.MessageClient, error) {
@@ -123,17 +165,33 @@ func newWatermillClientWithOptions(ctx context.Context, pub message.Publisher, s
 		unmarshaler: opt.Unmarshaler,
 		encryptor:   noopModifier,
 		decryptor:   noopModifier,
+		replyTopic:  defaultReplyTopicPrefix + uuid.NewString(),
+		pending:     make(map[string]chan types.MessageEnvelope),
 	}
 
 	var err error
 
 	if config != nil {
+		if config.ReplyTopicPrefix != "" {
+			client.replyTopic = config.ReplyTopicPrefix + uuid.NewString()
+		}
+
 		protection, err := newAESProtection(config)
 
 		if err == nil && protection != nil { // else err is going to be returned
 			client.encryptor = protection.encrypt
 			client.decryptor = protection.decrypt
 		}
+
+		client.metrics = newMetrics(config, nil)
+		client.health = newHealthTracker(config.Type)
+		client.clientId = config.ClientId
+
+		client.pub, err = spoolPublisher(instrumentPublisher(trackPublisher(pub, client.health), client.metrics), config, spoolLogger(opt.Logger))
+
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return client, err
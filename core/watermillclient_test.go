@@ -56,7 +56,7 @@ func TestPublish(t *testing.T) {
 	publisher.On("Publish", topic, marshaled).Return(nil)
 
 	marshaler := mockMarshaler{}
-	marshaler.On("Execute", payload, mock.AnythingOfType("core.binaryModifier")).Return(marshaled, nil)
+	marshaler.On("Execute", payload, mock.AnythingOfType("core.BinaryModifier")).Return(marshaled, nil)
 
 	client, err := newWatermillClientWithOptions(context.Background(), &publisher, nil, WatermillClientOptions{
 		Marshaler: marshaler.Execute,
@@ -21,6 +21,8 @@ import (
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/util"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
 )
 
 type WatermillSender interface {
@@ -29,32 +31,64 @@ type WatermillSender interface {
 
 type watermillSender struct {
 	pub              message.Publisher
-	encryptor        binaryModifier
+	marshaler        WatermillMarshaler
+	traceMarshaler   TraceContextMarshaler
+	encryptor        BinaryModifier
 	baseTopic        string
+	partitionKey     string
 	continuePipeline bool
 }
 
 func NewWatermillSender(pub message.Publisher, proceed bool, config *WatermillConfig) (WatermillSender, error) {
+	return NewWatermillSenderWithLogger(pub, proceed, config, nil)
+}
+
+// NewWatermillSenderWithLogger creates a sender that reports spool activity through lc
+func NewWatermillSenderWithLogger(pub message.Publisher, proceed bool, config *WatermillConfig, lc logger.LoggingClient) (WatermillSender, error) {
 	var err error
 
 	s := &watermillSender{
 		pub:              pub,
 		encryptor:        noopModifier,
 		baseTopic:        config.PublishTopic,
+		partitionKey:     config.PartitionKey,
 		continuePipeline: proceed,
 	}
 
 	if config != nil {
+		// sender has always published bare payloads, only use a wire format when asked to
+		if config.SenderWireFormat != "" {
+			format, err := LookupWireFormat(config.SenderWireFormat)
+
+			if err != nil {
+				return nil, err
+			}
+
+			s.marshaler = format.Marshal
+			s.traceMarshaler = traceContextMarshaler(format)
+		}
+
 		protection, err := newAESProtection(config)
 
 		if err == nil && protection != nil { // else err is going to be returned
 			s.encryptor = protection.encrypt
 		}
+
+		s.pub, err = spoolPublisher(instrumentPublisher(pub, newMetrics(config, nil)), config, spoolLogger(lc))
+
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return s, err
 }
 
+// SpoolDepth reports the number of messages waiting in the sender's spool
+func (ws *watermillSender) SpoolDepth() int {
+	return spoolDepth(ws.pub)
+}
+
 func (ws *watermillSender) Send(ctx interfaces.AppFunctionContext, data interface{}) (bool, interface{}) {
 	bytes, err := util.CoerceType(data)
 
@@ -62,19 +96,49 @@ func (ws *watermillSender) Send(ctx interfaces.AppFunctionContext, data interfac
 		return false, fmt.Errorf("Invalid message received for %s (%T) - could not convert to byte array for publishing", ctx.CorrelationID(), data)
 	}
 
-	ebytes, err := ws.encryptor(bytes)
+	topic, err := ctx.ApplyValues(ws.baseTopic)
 
 	if err != nil {
-		return false, fmt.Errorf("Failed to encrypt data.")
+		return false, err
 	}
 
-	topic, err := ctx.ApplyValues(ws.baseTopic)
+	var msg *message.Message
 
-	if err != nil {
-		return false, err
+	if ws.marshaler != nil {
+		contentType := ctx.ResponseContentType()
+
+		if contentType == "" {
+			contentType = ctx.InputContentType()
+		}
+
+		envelope := types.MessageEnvelope{
+			CorrelationID: ctx.CorrelationID(),
+			Payload:       bytes,
+			ContentType:   contentType,
+		}
+
+		if ws.traceMarshaler != nil {
+			msg, err = ws.traceMarshaler(pipelineTraceContext(ctx), envelope, ws.encryptor)
+		} else {
+			msg, err = ws.marshaler(envelope, ws.encryptor)
+		}
+
+		if err != nil {
+			return false, err
+		}
+	} else {
+		ebytes, err := ws.encryptor(bytes)
+
+		if err != nil {
+			return false, fmt.Errorf("Failed to encrypt data.")
+		}
+
+		msg = message.NewMessage(ctx.CorrelationID(), ebytes)
 	}
 
-	err = ws.pub.Publish(topic, message.NewMessage(ctx.CorrelationID(), ebytes))
+	setPartitionKey(ctx, msg, ws.partitionKey)
+
+	err = ws.pub.Publish(topic, msg)
 
 	if err != nil {
 		return false, nil
@@ -0,0 +1,61 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestSend_WireFormatIgnored(t *testing.T) {
+	pub := &flakyPublisher{}
+
+	// WireFormat is for the trigger and client, senders keep publishing bare payloads
+	sut, err := NewWatermillSender(pub, true, &WatermillConfig{PublishTopic: "out", WireFormat: EdgeXWireFormatName})
+
+	require.NoError(t, err)
+
+	proceed, _ := sut.Send(pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient()), []byte("payload"))
+
+	require.True(t, proceed)
+	require.Equal(t, 1, len(pub.published))
+	require.Equal(t, []byte("payload"), []byte(pub.published[0].Payload))
+}
+
+func TestSend_SenderWireFormat(t *testing.T) {
+	pub := &flakyPublisher{}
+
+	sut, err := NewWatermillSender(pub, true, &WatermillConfig{PublishTopic: "out", SenderWireFormat: EdgeXWireFormatName})
+
+	require.NoError(t, err)
+
+	proceed, _ := sut.Send(pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient()), []byte("payload"))
+
+	require.True(t, proceed)
+	require.Equal(t, 1, len(pub.published))
+
+	require.NotEqual(t, []byte("payload"), []byte(pub.published[0].Payload), "should be wrapped in an edgex envelope")
+}
+
+func TestNewWatermillSender_InvalidSenderWireFormat(t *testing.T) {
+	_, err := NewWatermillSender(&flakyPublisher{}, true, &WatermillConfig{SenderWireFormat: uuid.NewString()})
+
+	require.Error(t, err)
+}
@@ -20,87 +20,313 @@ import (
 	"context"
 	"fmt"
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	"github.com/cenkalti/backoff/v3"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/util"
 	"github.com/edgexfoundry/go-mod-bootstrap/v2/bootstrap"
 	"github.com/edgexfoundry/go-mod-core-contracts/v2/common"
 	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"go.opentelemetry.io/otel/trace"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
+const defaultDrainTimeout = 10 * time.Second
+
 type watermillTrigger struct {
 	pub             message.Publisher
 	sub             message.Subscriber
 	marshaler       WatermillMarshaler
 	unmarshaler     WatermillUnmarshaler
-	encryptor       binaryModifier
-	decryptor       binaryModifier
-	topics          []string
+	traceMarshaler  TraceContextMarshaler
+	encryptor       BinaryModifier
+	decryptor       BinaryModifier
+	retry           *retryPolicy
+	ordering        *orderingKey
+	dedup           *deduplicator
+	metrics         *metrics
+	tracing         *tracing
+	health          *healthTracker
+	inflight        inflightTracker
+	drainTimeout    time.Duration
+	subscriptions   *subscriptions
 	context         context.Context
 	cancel          context.CancelFunc
+	stopReading     context.CancelFunc
+	readers         sync.WaitGroup
+	users           *sync.WaitGroup
+	connMutex       sync.RWMutex
+	configMutex     sync.RWMutex
+	reloadMutex     sync.Mutex
 	watermillConfig *WatermillConfigWrapper
 	edgeXConfig     interfaces.TriggerConfig
 }
 
+// config returns the current trigger configuration, which may be replaced by Reconfigure
+func (t *watermillTrigger) config() WatermillConfig {
+	t.configMutex.RLock()
+	defer t.configMutex.RUnlock()
+
+	if t.watermillConfig == nil {
+		return WatermillConfig{}
+	}
+
+	return t.watermillConfig.WatermillTrigger
+}
+
+// Health reports the state of the trigger's broker connection
+func (t *watermillTrigger) Health() HealthStatus {
+	return t.health.Health()
+}
+
+// SpoolDepth reports the number of messages waiting in the trigger's spool
+func (t *watermillTrigger) SpoolDepth() int {
+	t.connMutex.RLock()
+	defer t.connMutex.RUnlock()
+
+	return spoolDepth(t.pub)
+}
+
 func (t *watermillTrigger) input(watermillMessage *message.Message, receiveTopic string) {
 	logger := t.edgeXConfig.Logger
 
-	msg, err := t.unmarshaler(watermillMessage, t.decryptor)
+	conn := t.acquire()
+	defer conn.release()
 
-	msg.ReceivedTopic = receiveTopic
+	var dedupKey string
 
-	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to unmarshal message: %s", err.Error()))
+	if conn.dedup != nil {
+		if dedupKey = conn.dedup.messageKey(watermillMessage); dedupKey != "" {
+			seen, err := conn.dedup.store.Seen(dedupKey)
+
+			if err != nil {
+				logger.Warn(fmt.Sprintf("Failed to check message %s for duplicates: %s", watermillMessage.UUID, err.Error()), "topic", receiveTopic)
+			} else if seen {
+				skipped := conn.dedup.skip()
+				logger.Info(fmt.Sprintf("Skipping duplicate message %s (%d duplicate(s) skipped)", watermillMessage.UUID, skipped), "topic", receiveTopic)
+				watermillMessage.Ack()
+				return
+			}
+		}
+	}
+
+	var bo backoff.BackOff
+
+	for attempt := 1; ; attempt++ {
+		watermillMessage.Metadata.Set(AttemptMetadataKey, strconv.Itoa(attempt))
+
+		err := t.process(conn, watermillMessage, receiveTopic)
+
+		if err == nil {
+			t.metrics.processed(receiveTopic)
+
+			if dedupKey != "" {
+				if err = conn.dedup.store.Mark(dedupKey, conn.dedup.ttl); err != nil {
+					logger.Warn(fmt.Sprintf("Failed to record message %s as processed: %s", watermillMessage.UUID, err.Error()), "topic", receiveTopic)
+				}
+			}
+
+			watermillMessage.Ack()
+			return
+		}
+
+		logger.Error(fmt.Sprintf("Failed to process message: %s", err.Error()), "topic", receiveTopic, "attempt", attempt)
+
+		t.metrics.failed(receiveTopic, err)
+
+		if conn.retry == nil || !conn.retry.shouldRetry(err, attempt) {
+			t.reject(conn, watermillMessage, receiveTopic, err, attempt)
+			return
+		}
+
+		if bo == nil {
+			bo = conn.retry.newBackOff()
+		}
+
+		select {
+		case <-t.context.Done():
+			// shutting down - leave redelivery to the broker
+			watermillMessage.Nack()
+			return
+		case <-time.After(bo.NextBackOff()):
+		}
+	}
+}
+
+// reject handles a message that will not be processed again, either dead lettering it or
+// leaving it to the broker when no retry policy is in place
+func (t *watermillTrigger) reject(conn *connectionSnapshot, watermillMessage *message.Message, receiveTopic string, cause error, attempts int) {
+	logger := t.edgeXConfig.Logger
+
+	if conn.pub != nil && t.config().DeadLetterTopic != "" {
+		err := t.deadLetter(conn, watermillMessage, receiveTopic, cause)
+
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to publish message %s to dead letter topic: %s", watermillMessage.UUID, err.Error()), "topic", receiveTopic)
+			watermillMessage.Nack()
+			return
+		}
+
+		logger.Warn(fmt.Sprintf("Moved message %s to dead letter topic after %d attempt(s)", watermillMessage.UUID, attempts), "topic", receiveTopic)
+		watermillMessage.Ack()
+		return
+	}
+
+	if conn.retry == nil {
 		watermillMessage.Nack()
 		return
 	}
 
+	// acknowledge so that the broker does not keep redelivering a message we can't handle
+	logger.Error(fmt.Sprintf("Giving up on message %s after %d attempt(s)", watermillMessage.UUID, attempts), "topic", receiveTopic)
+	watermillMessage.Ack()
+}
+
+// handle processes a message registered with the in flight tracker
+func (t *watermillTrigger) handle(watermillMessage *message.Message, receiveTopic string) {
+	defer t.inflight.end()
+
+	t.metrics.inFlight(1)
+	defer t.metrics.inFlight(-1)
+
+	t.input(watermillMessage, receiveTopic)
+}
+
+func (t *watermillTrigger) process(conn *connectionSnapshot, watermillMessage *message.Message, receiveTopic string) error {
+	logger := t.edgeXConfig.Logger
+
+	msg, err := conn.unmarshaler(watermillMessage, conn.decryptor)
+
+	// wire formats supporting trace context will have extracted it to the message's context
+	receiveCtx, receiveSpan := t.tracing.start(watermillMessage.Context(), "receive "+receiveTopic, trace.SpanKindConsumer)
+
+	if err != nil {
+		err = &processingError{class: ErrorClassUnmarshal, err: err}
+		endSpan(receiveSpan, err)
+		return err
+	}
+
+	msg.ReceivedTopic = receiveTopic
+
 	edgexContext := t.edgeXConfig.ContextBuilder(msg)
 
+	if replyTopic := watermillMessage.Metadata.Get(ReplyTopicMetadataKey); replyTopic != "" {
+		edgexContext.AddValue(ReplyTopicContextKey, replyTopic)
+	}
+
 	logger.Trace("Received message", "topic", receiveTopic, common.CorrelationHeader, edgexContext.CorrelationID)
 
-	//collect errors, consider failure if *any* pipeline fails on output
-	err = t.edgeXConfig.MessageReceived(edgexContext, msg, t.output)
+	pipelineCtx, pipelineSpan := t.tracing.start(receiveCtx, "pipeline "+receiveTopic, trace.SpanKindInternal)
+
+	// carried in the context values so output can continue the trace
+	tracePropagator.Inject(pipelineCtx, appContextCarrier{ctx: edgexContext})
+
+	started := time.Now()
+
+	receive := func() error {
+		//collect errors, consider failure if *any* pipeline fails on output
+		return t.edgeXConfig.MessageReceived(edgexContext, msg, t.respond(conn))
+	}
+
+	// pipeline state and output go to the outbox in one transaction
+	if outbox, ok := conn.pub.(*Outbox); ok {
+		err = outbox.share(edgexContext, receive)
+	} else {
+		err = receive()
+	}
+
+	t.metrics.pipelineDuration(receiveTopic, started)
 
 	if err != nil {
-		logger.Error(fmt.Sprintf("Failed to process message: %s", err.Error()))
-		watermillMessage.Nack()
-		return
+		err = &processingError{class: ErrorClassPipeline, err: err}
 	}
 
-	watermillMessage.Ack()
+	endSpan(pipelineSpan, err)
+	endSpan(receiveSpan, err)
+
+	return err
 }
 
 func (t *watermillTrigger) output(ctx interfaces.AppFunctionContext, pipeline *interfaces.FunctionPipeline) error {
+	conn := t.acquire()
+	defer conn.release()
+
+	return t.respond(conn)(ctx, pipeline)
+}
+
+// respond returns the response handler publishing pipeline output through conn
+func (t *watermillTrigger) respond(conn *connectionSnapshot) interfaces.PipelineResponseHandler {
+	return func(ctx interfaces.AppFunctionContext, pipeline *interfaces.FunctionPipeline) error {
+		err := t.publishOutput(conn, ctx, pipeline)
+
+		if err != nil && pipeline != nil {
+			return &pipelineError{pipelineId: pipeline.Id, err: err}
+		}
+
+		return err
+	}
+}
+
+func (t *watermillTrigger) publishOutput(conn *connectionSnapshot, ctx interfaces.AppFunctionContext, pipeline *interfaces.FunctionPipeline) error {
 	logger := ctx.LoggingClient()
 
 	output := ctx.ResponseData()
-	if output != nil && t.pub != nil {
+	if output != nil && conn.pub != nil {
 		var err error
 		pl := output
 
-		if t.encryptor != nil {
-			pl, err = t.encryptor(output)
+		if conn.encryptor != nil {
+			pl, err = conn.encryptor(output)
 		}
 
 		if err != nil {
 			return err
 		}
 
-		msg, err := t.marshaler(types.MessageEnvelope{
+		publishTopic, err := t.publishTopic(ctx, pipeline)
+
+		if err != nil {
+			return err
+		}
+
+		replyTopic, _ := ctx.GetValue(ReplyTopicContextKey)
+
+		// answer requests on the topic they asked for
+		if replyTopic != "" {
+			publishTopic = replyTopic
+		}
+
+		publishCtx, publishSpan := t.tracing.start(pipelineTraceContext(ctx), "publish "+publishTopic, trace.SpanKindProducer)
+
+		msg, err := conn.marshal(publishCtx, types.MessageEnvelope{
 			CorrelationID: ctx.CorrelationID(),
 			Payload:       pl,
 			ContentType:   ctx.ResponseContentType(),
-		}, t.encryptor)
+		})
 
 		if err != nil {
+			endSpan(publishSpan, err)
 			return err
 		}
 
-		publishTopic := t.watermillConfig.WatermillTrigger.PublishTopic
+		if replyTopic != "" {
+			msg.Metadata.Set(middleware.CorrelationIDMetadataKey, ctx.CorrelationID())
+		}
+
+		setPartitionKey(ctx, msg, t.config().PartitionKey)
+
+		if _, shared := ctx.GetValue(OutboxTxContextKey); shared {
+			err = WithOutboxTx(ctx, func(tx *OutboxTx) error {
+				return tx.Publish(publishTopic, msg)
+			})
+		} else {
+			err = conn.pub.Publish(publishTopic, msg)
+		}
 
-		err = t.pub.Publish(publishTopic, msg)
+		endSpan(publishSpan, err)
 
 		if err != nil {
 			return err
@@ -111,14 +337,33 @@ func (t *watermillTrigger) output(ctx interfaces.AppFunctionContext, pipeline *i
 	return nil
 }
 
+// publishTopic resolves the output topic for a pipeline, applying any context values
+// (eg. {devicename} or {receivedtopic}) referenced by the configured template
+func (t *watermillTrigger) publishTopic(ctx interfaces.AppFunctionContext, pipeline *interfaces.FunctionPipeline) (string, error) {
+	cfg := t.config()
+
+	topic := cfg.PublishTopic
+
+	if pipeline != nil {
+		if override, found := cfg.PipelinePublishTopics[pipeline.Id]; found {
+			topic = override
+		}
+	}
+
+	return ctx.ApplyValues(topic)
+}
+
 func (t *watermillTrigger) background(bg interfaces.BackgroundMessage) error {
-	msg, err := t.marshaler(bg.Message(), t.encryptor)
+	conn := t.acquire()
+	defer conn.release()
+
+	msg, err := conn.marshal(context.Background(), bg.Message())
 
 	if err != nil {
 		return err
 	}
 
-	err = t.pub.Publish(bg.Topic(), msg)
+	err = conn.pub.Publish(bg.Topic(), msg)
 
 	if err != nil {
 		return err
@@ -132,53 +377,26 @@ func (t *watermillTrigger) Initialize(wg *sync.WaitGroup, ctx context.Context, b
 
 	t.context, t.cancel = context.WithCancel(ctx)
 
-	cfg := t.watermillConfig.WatermillTrigger
+	cfg := t.config()
 
 	logger.Info(fmt.Sprintf("Initializing t for '%s'", cfg.Type))
 
-	logger.Info(fmt.Sprintf("Subscribing to topic: '%s' @ %s", cfg.SubscribeTopics, cfg.BrokerUrl))
+	t.health.register("trigger", "")
 
-	if len(strings.TrimSpace(cfg.SubscribeTopics)) == 0 {
-		// Still allows subscribing to blank topic to receive all messages
-		t.topics = append(t.topics, cfg.SubscribeTopics)
-	} else {
-		topics := util.DeleteEmptyAndTrim(strings.FieldsFunc(cfg.SubscribeTopics, util.SplitComma))
-		for _, topic := range topics {
-			t.topics = append(t.topics, topic)
-		}
+	if err := t.subscribe(cfg); err != nil {
+		t.health.close()
+		return nil, err
 	}
 
-	for _, topic := range t.topics {
-		if si, ok := t.sub.(message.SubscribeInitializer); ok {
-			err := si.SubscribeInitialize(topic)
-
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		tributary, err := t.sub.Subscribe(t.context, topic)
-
-		if err != nil {
-			return nil, err
-		}
-
-		wg.Add(1)
-
-		go func(waitgroup *sync.WaitGroup, collectFrom <-chan *message.Message, topic string) {
-			defer waitgroup.Done()
-			for {
-				select {
-				case <-t.context.Done():
-					return
+	// readers come and go as the trigger is reconfigured, hold the service open until all are done
+	wg.Add(1)
 
-				case m := <-collectFrom:
-					go t.input(m, topic)
+	go func() {
+		defer wg.Done()
 
-				}
-			}
-		}(wg, tributary, topic)
-	}
+		<-t.context.Done()
+		t.readers.Wait()
+	}()
 
 	wg.Add(1)
 
@@ -191,13 +409,44 @@ func (t *watermillTrigger) Initialize(wg *sync.WaitGroup, ctx context.Context, b
 				return
 
 			case bg := <-background:
-				go t.background(bg)
+				// held while the trigger is reconfigured, then published through the new connection
+				for !t.inflight.begin() {
+					if !t.inflight.wait(t.context) {
+						logger.Warn("Dropping background message received while shutting down", "topic", bg.Topic())
+						return
+					}
+				}
+
+				go func() {
+					defer t.inflight.end()
+
+					if err := t.background(bg); err != nil {
+						logger.Error(fmt.Sprintf("Failed to publish background message: %s", err.Error()), "topic", bg.Topic())
+					}
+				}()
 
 			}
 		}
 	}()
 
 	deferred := func() {
+		t.reloadMutex.Lock()
+		defer t.reloadMutex.Unlock()
+
+		// stop reading, but keep the subscriptions open so in flight messages can still be acked
+		t.cancel()
+		t.readers.Wait()
+
+		logger.Info(fmt.Sprintf("Draining in flight messages (timeout %s)", t.drainTimeout))
+
+		if abandoned := t.inflight.drain(t.drainTimeout); abandoned > 0 {
+			logger.Warn(fmt.Sprintf("Drain timed out, abandoning %d in flight message(s)", abandoned))
+		}
+
+		if t.subscriptions != nil {
+			t.subscriptions.unsubscribe()
+		}
+
 		logger.Info("Disconnecting t")
 		if t.sub != nil {
 			err := t.sub.Close()
@@ -212,6 +461,22 @@ func (t *watermillTrigger) Initialize(wg *sync.WaitGroup, ctx context.Context, b
 				logger.Error("Unable to disconnect t Publisher", "error", err.Error())
 			}
 		}
+
+		if t.dedup != nil {
+			if err := t.dedup.store.Close(); err != nil {
+				logger.Error("Unable to close dedup store", "error", err.Error())
+			}
+		}
+
+		t.health.disconnected(nil)
+		t.health.close()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := t.tracing.shutdown(shutdownCtx); err != nil {
+			logger.Error("Unable to flush traces", "error", err.Error())
+		}
 	}
 
 	return deferred, nil
@@ -223,26 +488,250 @@ func NewWatermillTrigger(publisher message.Publisher, subscriber message.Subscri
 		sub:             subscriber,
 		watermillConfig: watermillConfig,
 		edgeXConfig:     edgeXConfig,
-		marshaler:       format.marshal,
-		unmarshaler:     format.unmarshal,
+		marshaler:       format.Marshal,
+		unmarshaler:     format.Unmarshal,
+		traceMarshaler:  traceContextMarshaler(format),
 		encryptor:       noopModifier,
 		decryptor:       noopModifier,
+		drainTimeout:    defaultDrainTimeout,
+		users:           &sync.WaitGroup{},
 	}
 
 	var err error
 
 	if watermillConfig != nil {
+		t.health = newHealthTracker(watermillConfig.WatermillTrigger.Type)
+
 		protection, err := newAESProtection(&(watermillConfig.WatermillTrigger))
 
-		if err == nil && protection != nil { // else err is going to be returned
+		if err != nil {
+			return nil, err
+		}
+
+		if protection != nil {
 			t.encryptor = protection.encrypt
 			t.decryptor = protection.decrypt
 		}
+
+		t.retry, err = newRetryPolicy(watermillConfig.WatermillTrigger.Retry)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.ordering, err = parseOrderingKey(watermillConfig.WatermillTrigger.OrderingKey)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.tracing, err = newTracing(watermillConfig.WatermillTrigger.Tracing)
+
+		if err != nil {
+			return nil, err
+		}
+
+		t.dedup, err = newDeduplicator(watermillConfig.WatermillTrigger.Dedup)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if dt := watermillConfig.WatermillTrigger.DrainTimeout; dt != "" {
+			if t.drainTimeout, err = time.ParseDuration(dt); err != nil {
+				return nil, fmt.Errorf("invalid drain timeout: %s", err.Error())
+			}
+		}
+
+		t.metrics = newMetrics(&(watermillConfig.WatermillTrigger), func(err error) {
+			edgeXConfig.Logger.Error(fmt.Sprintf("Metrics endpoint failed: %s", err.Error()))
+		})
+
+		t.pub, err = t.wrapPublisher(publisher, &(watermillConfig.WatermillTrigger))
+
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return t, err
 }
 
+// subscriptions are the open subscriptions a trigger's readers collect messages from
+type subscriptions struct {
+	topics      []string
+	channels    []<-chan *message.Message
+	unsubscribe context.CancelFunc
+}
+
+// subscribe starts reading from each configured topic until the trigger is stopped or reconfigured
+func (t *watermillTrigger) subscribe(cfg WatermillConfig) error {
+	subs, err := t.openSubscriptions(t.sub, cfg)
+
+	if err != nil {
+		t.health.disconnected(err)
+		return err
+	}
+
+	t.startReading(subs, cfg)
+
+	return nil
+}
+
+// openSubscriptions subscribes sub to each configured topic
+func (t *watermillTrigger) openSubscriptions(sub message.Subscriber, cfg WatermillConfig) (*subscriptions, error) {
+	logger := t.edgeXConfig.Logger
+
+	logger.Info(fmt.Sprintf("Subscribing to topic: '%s' @ %s", cfg.SubscribeTopics, cfg.BrokerUrl))
+
+	subs := &subscriptions{}
+
+	if len(strings.TrimSpace(cfg.SubscribeTopics)) == 0 {
+		// Still allows subscribing to blank topic to receive all messages
+		subs.topics = append(subs.topics, cfg.SubscribeTopics)
+	} else {
+		topics := util.DeleteEmptyAndTrim(strings.FieldsFunc(cfg.SubscribeTopics, util.SplitComma))
+		for _, topic := range topics {
+			subs.topics = append(subs.topics, topic)
+		}
+	}
+
+	// subscriptions outlive the readers so that messages still in flight after reading stops can
+	// be acked, some backends drop acks once the subscription's context is done
+	ctx, unsubscribe := context.WithCancel(context.Background())
+
+	subs.unsubscribe = unsubscribe
+
+	for _, topic := range subs.topics {
+		if si, ok := sub.(message.SubscribeInitializer); ok {
+			err := si.SubscribeInitialize(topic)
+
+			if err != nil {
+				logger.Error(fmt.Sprintf("Failed to initialize subscription: %s", err.Error()), "backend", cfg.Type, "topic", topic)
+				unsubscribe()
+				return nil, err
+			}
+		}
+
+		tributary, err := sub.Subscribe(ctx, topic)
+
+		if err != nil {
+			logger.Error(fmt.Sprintf("Failed to subscribe: %s", err.Error()), "backend", cfg.Type, "topic", topic)
+			unsubscribe()
+			return nil, err
+		}
+
+		subs.channels = append(subs.channels, tributary)
+	}
+
+	return subs, nil
+}
+
+// startReading collects messages from subs until the trigger is stopped or reconfigured
+func (t *watermillTrigger) startReading(subs *subscriptions, cfg WatermillConfig) {
+	logger := t.edgeXConfig.Logger
+
+	ctx, cancel := context.WithCancel(t.context)
+
+	t.stopReading = cancel
+	t.subscriptions = subs
+
+	newDispatch := func() (*workerPool, *orderedDispatcher) {
+		if t.ordering == nil {
+			return newWorkerPool(cfg.MaxConcurrency), nil
+		}
+
+		// ordering workers take the place of the worker pool
+		workers := cfg.OrderingWorkers
+
+		if workers <= 0 {
+			workers = cfg.MaxConcurrency
+		}
+
+		return newWorkerPool(0), newOrderedDispatcher(ctx, workers, t.handle)
+	}
+
+	pool, ordered := newDispatch()
+
+	for i, topic := range subs.topics {
+		if cfg.ConcurrencyPerTopic {
+			pool, ordered = newDispatch()
+		}
+
+		t.readers.Add(1)
+
+		go func(collectFrom <-chan *message.Message, topic string, pool *workerPool, ordered *orderedDispatcher) {
+			defer t.readers.Done()
+			for {
+				// stop reading from the subscriber while all workers are busy
+				if !pool.acquire(ctx) {
+					return
+				}
+
+				select {
+				case <-ctx.Done():
+					pool.release()
+					return
+
+				case m, ok := <-collectFrom:
+					if !ok {
+						if ctx.Err() == nil {
+							logger.Warn("Subscription closed by backend", "backend", cfg.Type, "topic", topic)
+							t.health.disconnected(fmt.Errorf("subscription to %s closed", topic))
+						}
+						pool.release()
+						return
+					}
+
+					t.metrics.received(topic)
+					t.health.received()
+
+					if !t.inflight.begin() {
+						// draining, leave the message for redelivery
+						m.Nack()
+						pool.release()
+						return
+					}
+
+					if ordered != nil {
+						if !ordered.dispatch(ctx, t.orderingKey(m), m, topic) {
+							t.inflight.end()
+							m.Nack()
+						}
+						pool.release()
+						continue
+					}
+
+					go func() {
+						defer pool.release()
+						t.handle(m, topic)
+					}()
+				}
+			}
+		}(subs.channels[i], topic, pool, ordered)
+	}
+
+	t.health.connected()
+}
+
+// wrapPublisher adds the health, metrics, spool and outbox decorators enabled by config
+func (t *watermillTrigger) wrapPublisher(publisher message.Publisher, config *WatermillConfig) (message.Publisher, error) {
+	pub, err := spoolPublisher(t.instrumentPublisher(publisher), config, NewLogAdapter(t.edgeXConfig.Logger, nil))
+
+	if err != nil {
+		return nil, err
+	}
+
+	return outboxPublisher(pub, config, NewLogAdapter(t.edgeXConfig.Logger, nil))
+}
+
+// instrumentPublisher records health and metrics for each publish to the broker itself
+func (t *watermillTrigger) instrumentPublisher(publisher message.Publisher) message.Publisher {
+	return instrumentPublisher(trackPublisher(publisher, t.health), t.metrics)
+}
+
+// Stop stops receiving new messages.  Subscriptions and connections are closed by the deferred
+// shutdown function once in flight messages have drained.
 func (t *watermillTrigger) Stop() {
 	if t.cancel != nil {
 		t.cancel()
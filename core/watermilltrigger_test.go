@@ -18,6 +18,7 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg"
@@ -27,7 +28,9 @@ import (
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestOutput_NilOutputData(t *testing.T) {
@@ -61,7 +64,7 @@ func TestOutput_MarshalError(t *testing.T) {
 
 	marshaler.On("Execute", mock.MatchedBy(func(envelope types.MessageEnvelope) bool {
 		return ctx.CorrelationID() == envelope.CorrelationID && ctx.ResponseContentType() == envelope.ContentType && bytes.Equal(ctx.ResponseData(), envelope.Payload)
-	}), mock.AnythingOfType("core.binaryModifier")).Return(msg, errors.New(""))
+	}), mock.AnythingOfType("core.BinaryModifier")).Return(msg, errors.New(""))
 
 	sut := watermillTrigger{marshaler: marshaler.Execute, pub: &mockPublisher{}, watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{PublishTopic: topic}}}
 
@@ -82,7 +85,7 @@ func TestOutput_PublishError(t *testing.T) {
 
 	marshaler.On("Execute", mock.MatchedBy(func(envelope types.MessageEnvelope) bool {
 		return ctx.CorrelationID() == envelope.CorrelationID && ctx.ResponseContentType() == envelope.ContentType && bytes.Equal(ctx.ResponseData(), envelope.Payload)
-	}), mock.AnythingOfType("core.binaryModifier")).Return(msg, nil)
+	}), mock.AnythingOfType("core.BinaryModifier")).Return(msg, nil)
 
 	pub := mockPublisher{}
 	pub.On("Publish", topic, msg).Return(errors.New(""))
@@ -108,7 +111,7 @@ func TestOutput(t *testing.T) {
 	marshaler := mockMarshaler{}
 	marshaler.On("Execute", mock.MatchedBy(func(envelope types.MessageEnvelope) bool {
 		return ctx.CorrelationID() == envelope.CorrelationID && ctx.ResponseContentType() == envelope.ContentType && bytes.Equal(ctx.ResponseData(), envelope.Payload)
-	}), mock.AnythingOfType("core.binaryModifier")).Return(&marshaled, nil)
+	}), mock.AnythingOfType("core.BinaryModifier")).Return(&marshaled, nil)
 
 	sut := watermillTrigger{pub: &pub, marshaler: marshaler.Execute, watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{PublishTopic: topic}}}
 
@@ -128,6 +131,129 @@ func TestOutput(t *testing.T) {
 	require.Equal(t, &marshaled, msg)
 }
 
+func TestOutput_TopicTemplate(t *testing.T) {
+	ctx := pkg.NewAppFuncContextForTest(uuid.NewString(), logger.MockLogger{})
+	ctx.SetResponseData([]byte{})
+	ctx.AddValue(interfaces.DEVICENAME, "thermostat")
+	ctx.AddValue(interfaces.RECEIVEDTOPIC, "incoming")
+
+	marshaled := message.Message{}
+
+	pub := mockPublisher{}
+	pub.On("Publish", "events/thermostat/incoming", &marshaled).Return(nil)
+
+	marshaler := mockMarshaler{}
+	marshaler.On("Execute", mock.Anything, mock.Anything).Return(&marshaled, nil)
+
+	sut := watermillTrigger{pub: &pub, marshaler: marshaler.Execute, watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{PublishTopic: "events/{devicename}/{receivedtopic}"}}}
+
+	err := sut.output(ctx, &interfaces.FunctionPipeline{})
+
+	require.NoError(t, err)
+	pub.AssertExpectations(t)
+}
+
+func TestOutput_TopicTemplate_MissingValue(t *testing.T) {
+	ctx := pkg.NewAppFuncContextForTest(uuid.NewString(), logger.MockLogger{})
+	ctx.SetResponseData([]byte{})
+
+	marshaler := mockMarshaler{}
+	marshaler.On("Execute", mock.Anything, mock.Anything).Return(&message.Message{}, nil)
+
+	sut := watermillTrigger{pub: &mockPublisher{}, marshaler: marshaler.Execute, watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{PublishTopic: "events/{devicename}"}}}
+
+	err := sut.output(ctx, &interfaces.FunctionPipeline{})
+
+	require.Error(t, err)
+}
+
+func TestOutput_PartitionKeyTemplate(t *testing.T) {
+	ctx := pkg.NewAppFuncContextForTest(uuid.NewString(), logger.MockLogger{})
+	ctx.SetResponseData([]byte{})
+	ctx.AddValue(interfaces.DEVICENAME, "thermostat")
+
+	marshaled := message.NewMessage(uuid.NewString(), nil)
+
+	pub := mockPublisher{}
+	pub.On("Publish", "events", marshaled).Return(nil)
+
+	marshaler := mockMarshaler{}
+	marshaler.On("Execute", mock.Anything, mock.Anything).Return(marshaled, nil)
+
+	sut := watermillTrigger{pub: &pub, marshaler: marshaler.Execute, watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{PublishTopic: "events", PartitionKey: "site-1/{devicename}"}}}
+
+	err := sut.output(ctx, &interfaces.FunctionPipeline{})
+
+	require.NoError(t, err)
+	pub.AssertExpectations(t)
+	require.Equal(t, "site-1/thermostat", marshaled.Metadata.Get(PartitionKeyMetadataKey))
+}
+
+func TestOutput_PartitionKeyTemplate_MissingValue(t *testing.T) {
+	ctx := pkg.NewAppFuncContextForTest(uuid.NewString(), logger.MockLogger{})
+	ctx.SetResponseData([]byte{})
+
+	marshaled := message.NewMessage(uuid.NewString(), nil)
+
+	marshaler := mockMarshaler{}
+	marshaler.On("Execute", mock.Anything, mock.Anything).Return(marshaled, nil)
+
+	pub := mockPublisher{}
+	pub.On("Publish", "events", marshaled).Return(nil)
+
+	sut := watermillTrigger{pub: &pub, marshaler: marshaler.Execute, watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{PublishTopic: "events", PartitionKey: "{devicename}"}}}
+
+	err := sut.output(ctx, &interfaces.FunctionPipeline{})
+
+	require.NoError(t, err)
+	pub.AssertCalled(t, "Publish", "events", marshaled)
+	require.Empty(t, marshaled.Metadata.Get(PartitionKeyMetadataKey), "should publish without a partition key")
+}
+
+func TestOutput_PartitionKeyMetadata(t *testing.T) {
+	ctx := pkg.NewAppFuncContextForTest(uuid.NewString(), logger.MockLogger{})
+	ctx.SetResponseData([]byte{})
+
+	marshaled := message.NewMessage(uuid.NewString(), nil)
+
+	pub := mockPublisher{}
+	pub.On("Publish", "events", marshaled).Return(nil)
+
+	marshaler := mockMarshaler{}
+	marshaler.On("Execute", mock.Anything, mock.Anything).Return(marshaled, nil)
+
+	sut := watermillTrigger{pub: &pub, marshaler: marshaler.Execute, watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{PublishTopic: "events", PartitionKey: "metadata:device"}}}
+
+	require.NoError(t, sut.output(ctx, &interfaces.FunctionPipeline{}))
+	require.Empty(t, marshaled.Metadata.Get(PartitionKeyMetadataKey), "only templates are resolved by the trigger")
+}
+
+func TestOutput_PipelineTopic(t *testing.T) {
+	pipelineId := uuid.NewString()
+
+	ctx := pkg.NewAppFuncContextForTest(uuid.NewString(), logger.MockLogger{})
+	ctx.SetResponseData([]byte{})
+	ctx.AddValue(interfaces.DEVICENAME, "thermostat")
+
+	marshaled := message.Message{}
+
+	pub := mockPublisher{}
+	pub.On("Publish", "pipeline/thermostat", &marshaled).Return(nil)
+
+	marshaler := mockMarshaler{}
+	marshaler.On("Execute", mock.Anything, mock.Anything).Return(&marshaled, nil)
+
+	sut := watermillTrigger{pub: &pub, marshaler: marshaler.Execute, watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{
+		PublishTopic:          uuid.NewString(),
+		PipelinePublishTopics: map[string]string{pipelineId: "pipeline/{devicename}"},
+	}}}
+
+	err := sut.output(ctx, &interfaces.FunctionPipeline{Id: pipelineId})
+
+	require.NoError(t, err)
+	pub.AssertExpectations(t)
+}
+
 func TestBackground_MarshalError(t *testing.T) {
 	topic := uuid.NewString()
 
@@ -139,7 +265,7 @@ func TestBackground_MarshalError(t *testing.T) {
 
 	marshaler := mockMarshaler{}
 
-	marshaler.On("Execute", env, mock.AnythingOfType("core.binaryModifier")).Return(nil, errors.New(""))
+	marshaler.On("Execute", env, mock.AnythingOfType("core.BinaryModifier")).Return(nil, errors.New(""))
 
 	sut := watermillTrigger{marshaler: marshaler.Execute}
 
@@ -161,7 +287,7 @@ func TestBackground_PublishError(t *testing.T) {
 
 	marshaler := mockMarshaler{}
 
-	marshaler.On("Execute", env, mock.AnythingOfType("core.binaryModifier")).Return(msg, nil)
+	marshaler.On("Execute", env, mock.AnythingOfType("core.BinaryModifier")).Return(msg, nil)
 
 	pub := mockPublisher{}
 	pub.On("Publish", topic, msg).Return(errors.New(""))
@@ -188,7 +314,7 @@ func TestBackground(t *testing.T) {
 	pub.On("Publish", topic, &marshaled).Return(nil)
 
 	marshaler := mockMarshaler{}
-	marshaler.On("Execute", env, mock.AnythingOfType("core.binaryModifier")).Return(&marshaled, nil)
+	marshaler.On("Execute", env, mock.AnythingOfType("core.BinaryModifier")).Return(&marshaled, nil)
 
 	sut := watermillTrigger{pub: &pub, marshaler: marshaler.Execute}
 
@@ -203,6 +329,333 @@ func TestBackground(t *testing.T) {
 	require.Equal(t, &marshaled, msg)
 }
 
+func newInputTestTrigger(t *testing.T, unmarshalErr error, pipelineErrs ...error) (*watermillTrigger, *int) {
+	calls := 0
+
+	unmarshaler := mockUnmarshaler{}
+	unmarshaler.On("Execute", mock.Anything, mock.Anything).Return(types.MessageEnvelope{}, unmarshalErr)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	return &watermillTrigger{
+		unmarshaler: unmarshaler.Execute,
+		context:     ctx,
+		edgeXConfig: interfaces.TriggerConfig{
+			Logger: logger.NewMockClient(),
+			ContextBuilder: func(env types.MessageEnvelope) interfaces.AppFunctionContext {
+				return pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient())
+			},
+			MessageReceived: func(ctx interfaces.AppFunctionContext, envelope types.MessageEnvelope, responseHandler interfaces.PipelineResponseHandler) error {
+				var err error
+				if calls < len(pipelineErrs) {
+					err = pipelineErrs[calls]
+				}
+				calls++
+				return err
+			},
+		},
+	}, &calls
+}
+
+func requireAcked(t *testing.T, msg *message.Message) {
+	select {
+	case <-msg.Acked():
+	case <-msg.Nacked():
+		require.Fail(t, "message should be acked")
+	default:
+		require.Fail(t, "message should be acked")
+	}
+}
+
+func requireNacked(t *testing.T, msg *message.Message) {
+	select {
+	case <-msg.Nacked():
+	case <-msg.Acked():
+		require.Fail(t, "message should be nacked")
+	default:
+		require.Fail(t, "message should be nacked")
+	}
+}
+
+func TestInput(t *testing.T) {
+	sut, calls := newInputTestTrigger(t, nil)
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+
+	sut.input(msg, uuid.NewString())
+
+	require.Equal(t, 1, *calls)
+	require.Equal(t, "1", msg.Metadata.Get(AttemptMetadataKey))
+	requireAcked(t, msg)
+}
+
+func TestInput_NoRetry_Nack(t *testing.T) {
+	sut, calls := newInputTestTrigger(t, nil, errors.New("pipeline"))
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+
+	sut.input(msg, uuid.NewString())
+
+	require.Equal(t, 1, *calls)
+	requireNacked(t, msg)
+}
+
+func TestInput_Retry(t *testing.T) {
+	sut, calls := newInputTestTrigger(t, nil, errors.New("pipeline"), errors.New("pipeline"))
+
+	sut.retry, _ = newRetryPolicy(RetryConfig{MaxAttempts: 3, InitialInterval: "1ms"})
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+
+	sut.input(msg, uuid.NewString())
+
+	require.Equal(t, 3, *calls)
+	require.Equal(t, "3", msg.Metadata.Get(AttemptMetadataKey))
+	requireAcked(t, msg)
+}
+
+func TestInput_Retry_Exhausted(t *testing.T) {
+	sut, calls := newInputTestTrigger(t, nil, errors.New("pipeline"), errors.New("pipeline"), errors.New("pipeline"))
+
+	sut.retry, _ = newRetryPolicy(RetryConfig{MaxAttempts: 2, InitialInterval: "1ms"})
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+
+	sut.input(msg, uuid.NewString())
+
+	require.Equal(t, 2, *calls)
+	requireAcked(t, msg)
+}
+
+func TestInput_Retry_NotRetryable(t *testing.T) {
+	sut, calls := newInputTestTrigger(t, errors.New("unmarshal"))
+
+	sut.retry, _ = newRetryPolicy(RetryConfig{MaxAttempts: 3, InitialInterval: "1ms", RetryOn: ErrorClassPipeline})
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+
+	sut.input(msg, uuid.NewString())
+
+	require.Equal(t, 0, *calls)
+	require.Equal(t, "1", msg.Metadata.Get(AttemptMetadataKey))
+	requireAcked(t, msg)
+}
+
+func TestInput_DeadLetter(t *testing.T) {
+	dlq := uuid.NewString()
+	topic := uuid.NewString()
+
+	sut, calls := newInputTestTrigger(t, nil, errors.New("pipeline"))
+
+	pub := mockPublisher{}
+	pub.On("Publish", dlq, mock.Anything).Return(nil)
+
+	sut.pub = &pub
+	sut.watermillConfig = &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{DeadLetterTopic: dlq}}
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+	msg.Metadata.Set("original", "value")
+
+	sut.input(msg, topic)
+
+	require.Equal(t, 1, *calls)
+	requireAcked(t, msg)
+
+	require.Equal(t, 1, len(pub.Calls))
+	dead := pub.Calls[0].Arguments[1].(*message.Message)
+	require.Equal(t, msg.UUID, dead.UUID)
+	require.Equal(t, msg.Payload, dead.Payload)
+	require.Equal(t, "value", dead.Metadata.Get("original"))
+	require.Contains(t, dead.Metadata.Get(DeadLetterErrorKey), "pipeline")
+	require.Equal(t, topic, dead.Metadata.Get(DeadLetterTopicKey))
+	require.NotEmpty(t, dead.Metadata.Get(DeadLetterTimeKey))
+}
+
+func TestInput_DeadLetter_AfterRetry(t *testing.T) {
+	dlq := uuid.NewString()
+
+	sut, calls := newInputTestTrigger(t, nil, errors.New("pipeline"), errors.New("pipeline"))
+
+	pub := mockPublisher{}
+	pub.On("Publish", dlq, mock.Anything).Return(nil)
+
+	sut.pub = &pub
+	sut.watermillConfig = &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{DeadLetterTopic: dlq}}
+	sut.retry, _ = newRetryPolicy(RetryConfig{MaxAttempts: 2, InitialInterval: "1ms"})
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+
+	sut.input(msg, uuid.NewString())
+
+	require.Equal(t, 2, *calls)
+	requireAcked(t, msg)
+	require.Equal(t, 1, len(pub.Calls))
+}
+
+func TestInput_DeadLetter_PublishError(t *testing.T) {
+	dlq := uuid.NewString()
+
+	sut, _ := newInputTestTrigger(t, errors.New("unmarshal"))
+
+	pub := mockPublisher{}
+	pub.On("Publish", dlq, mock.Anything).Return(errors.New("publish"))
+
+	sut.pub = &pub
+	sut.watermillConfig = &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{DeadLetterTopic: dlq}}
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+
+	sut.input(msg, uuid.NewString())
+
+	requireNacked(t, msg)
+}
+
+func TestNewWatermillTrigger_InvalidEncryptionKey(t *testing.T) {
+	config := WatermillConfig{
+		EncryptionAlgorithm: AES256SHA512,
+		EncryptionKey:       "not hex",
+		Retry:               RetryConfig{MaxAttempts: 3},
+	}
+
+	_, err := NewWatermillTrigger(nil, nil, &RawWireFormat{}, &WatermillConfigWrapper{WatermillTrigger: config}, interfaces.TriggerConfig{Logger: logger.NewMockClient()})
+
+	require.Error(t, err)
+}
+
+func TestInitialize_MaxConcurrency(t *testing.T) {
+	topic := uuid.NewString()
+	msgs := make(chan *message.Message)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sub := mockSubscriber{}
+	sub.On("Subscribe", mock.Anything, topic).Return(msgs, nil)
+
+	unmarshaler := mockUnmarshaler{}
+	unmarshaler.On("Execute", mock.Anything, mock.Anything).Return(types.MessageEnvelope{}, nil)
+
+	started := make(chan struct{}, 10)
+	proceed := make(chan struct{})
+
+	sut := &watermillTrigger{
+		sub:         &sub,
+		unmarshaler: unmarshaler.Execute,
+		watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{
+			SubscribeTopics: topic,
+			MaxConcurrency:  1,
+		}},
+		edgeXConfig: interfaces.TriggerConfig{
+			Logger: logger.NewMockClient(),
+			ContextBuilder: func(env types.MessageEnvelope) interfaces.AppFunctionContext {
+				return pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient())
+			},
+			MessageReceived: func(ctx interfaces.AppFunctionContext, envelope types.MessageEnvelope, responseHandler interfaces.PipelineResponseHandler) error {
+				started <- struct{}{}
+				<-proceed
+				return nil
+			},
+		},
+	}
+
+	_, err := sut.Initialize(&sync.WaitGroup{}, ctx, nil)
+
+	require.NoError(t, err)
+
+	msgs <- message.NewMessage(uuid.NewString(), []byte("{}"))
+
+	<-started
+
+	select {
+	case msgs <- message.NewMessage(uuid.NewString(), []byte("{}")):
+		require.Fail(t, "should not read from subscriber while workers are busy")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(proceed)
+
+	select {
+	case msgs <- message.NewMessage(uuid.NewString(), []byte("{}")):
+	case <-time.After(time.Second):
+		require.Fail(t, "should read from subscriber once a worker is free")
+	}
+}
+
+func TestInitialize_DrainBeforeClose(t *testing.T) {
+	topic := uuid.NewString()
+	msgs := make(chan *message.Message)
+
+	sub := mockSubscriber{}
+	sub.On("Subscribe", mock.Anything, topic).Return(msgs, nil)
+	sub.On("Close").Return(nil)
+
+	pub := mockPublisher{}
+	pub.On("Close").Return(nil)
+
+	unmarshaler := mockUnmarshaler{}
+	unmarshaler.On("Execute", mock.Anything, mock.Anything).Return(types.MessageEnvelope{}, nil)
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+
+	sut := &watermillTrigger{
+		sub:          &sub,
+		pub:          &pub,
+		unmarshaler:  unmarshaler.Execute,
+		drainTimeout: time.Second,
+		watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{
+			SubscribeTopics: topic,
+		}},
+		edgeXConfig: interfaces.TriggerConfig{
+			Logger: logger.NewMockClient(),
+			ContextBuilder: func(env types.MessageEnvelope) interfaces.AppFunctionContext {
+				return pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient())
+			},
+			MessageReceived: func(ctx interfaces.AppFunctionContext, envelope types.MessageEnvelope, responseHandler interfaces.PipelineResponseHandler) error {
+				close(started)
+				<-proceed
+				return nil
+			},
+		},
+	}
+
+	deferred, err := sut.Initialize(&sync.WaitGroup{}, context.Background(), nil)
+
+	require.NoError(t, err)
+
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+
+	msgs <- msg
+
+	<-started
+
+	done := make(chan struct{})
+
+	go func() {
+		deferred()
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return draining(&sut.inflight) }, time.Second, time.Millisecond)
+
+	select {
+	case <-done:
+		require.Fail(t, "should wait for in flight messages before closing")
+	default:
+	}
+
+	sub.AssertNotCalled(t, "Close")
+
+	close(proceed)
+
+	<-done
+
+	requireAcked(t, msg)
+	sub.AssertCalled(t, "Close")
+	pub.AssertCalled(t, "Close")
+}
+
 type MockBackgroundMessage struct {
 	env   types.MessageEnvelope
 	topic string
@@ -215,3 +668,92 @@ func (bm MockBackgroundMessage) Message() types.MessageEnvelope {
 func (bm MockBackgroundMessage) Topic() string {
 	return bm.topic
 }
+
+// committingSubscription delivers msg then, like the kafka subscriber, only commits an ack made
+// before the subscription's context is done, reporting whether it was
+func committingSubscription(msg *message.Message, committed chan<- bool) func(context.Context, string) chan *message.Message {
+	return func(ctx context.Context, topic string) chan *message.Message {
+		out := make(chan *message.Message)
+
+		go func() {
+			out <- msg
+
+			<-ctx.Done()
+
+			select {
+			case <-msg.Acked():
+				committed <- true
+			default:
+				committed <- false
+			}
+		}()
+
+		return out
+	}
+}
+
+func TestInitialize_AckAfterShutdownStarts(t *testing.T) {
+	topic := uuid.NewString()
+	msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+	committed := make(chan bool, 1)
+
+	sub := mockSubscriber{}
+	sub.On("Subscribe", mock.Anything, topic).Return(committingSubscription(msg, committed), nil)
+	sub.On("Close").Return(nil)
+
+	pub := mockPublisher{}
+	pub.On("Close").Return(nil)
+
+	unmarshaler := mockUnmarshaler{}
+	unmarshaler.On("Execute", mock.Anything, mock.Anything).Return(types.MessageEnvelope{}, nil)
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+
+	sut := &watermillTrigger{
+		sub:          &sub,
+		pub:          &pub,
+		unmarshaler:  unmarshaler.Execute,
+		drainTimeout: time.Second,
+		watermillConfig: &WatermillConfigWrapper{WatermillTrigger: WatermillConfig{
+			SubscribeTopics: topic,
+		}},
+		edgeXConfig: interfaces.TriggerConfig{
+			Logger: logger.NewMockClient(),
+			ContextBuilder: func(env types.MessageEnvelope) interfaces.AppFunctionContext {
+				return pkg.NewAppFuncContextForTest(uuid.NewString(), logger.NewMockClient())
+			},
+			MessageReceived: func(ctx interfaces.AppFunctionContext, envelope types.MessageEnvelope, responseHandler interfaces.PipelineResponseHandler) error {
+				close(started)
+				<-proceed
+				return nil
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	deferred, err := sut.Initialize(&sync.WaitGroup{}, ctx, nil)
+
+	require.NoError(t, err)
+
+	<-started
+
+	// the SDK cancels the service context before running deferred shutdown functions
+	cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		deferred()
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return draining(&sut.inflight) }, time.Second, time.Millisecond)
+
+	close(proceed)
+
+	<-done
+
+	require.True(t, <-committed, "ack should reach the subscriber before its context is cancelled")
+}
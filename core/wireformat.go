@@ -17,15 +17,116 @@
 package core
 
 import (
+	"fmt"
 	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
 	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
+	"sort"
+	"strings"
+	"sync"
 )
 
+// WireFormat converts between EdgeX message envelopes and watermill messages.  The passed
+// BinaryModifier applies any configured payload protection and may be nil.
 type WireFormat interface {
-	marshal(envelope types.MessageEnvelope, encrypt binaryModifier) (*message.Message, error)
-	unmarshal(msg *message.Message, decrypt binaryModifier) (types.MessageEnvelope, error)
+	Marshal(envelope types.MessageEnvelope, encrypt BinaryModifier) (*message.Message, error)
+	Unmarshal(msg *message.Message, decrypt BinaryModifier) (types.MessageEnvelope, error)
 }
 
-type WatermillMarshaler func(envelope types.MessageEnvelope, encrypt binaryModifier) (*message.Message, error)
+type WatermillMarshaler func(envelope types.MessageEnvelope, encrypt BinaryModifier) (*message.Message, error)
 
-type WatermillUnmarshaler func(msg *message.Message, decrypt binaryModifier) (types.MessageEnvelope, error)
+type WatermillUnmarshaler func(msg *message.Message, decrypt BinaryModifier) (types.MessageEnvelope, error)
+
+const (
+	RawWireFormatName       = "raw"
+	RawInputWireFormatName  = "rawinput"
+	RawOutputWireFormatName = "rawoutput"
+	EdgeXWireFormatName     = "edgex"
+)
+
+var (
+	wireFormatsMutex sync.RWMutex
+	wireFormats      = map[string]WireFormat{
+		RawWireFormatName:       &RawWireFormat{},
+		RawInputWireFormatName:  &RawInputWireFormat{},
+		RawOutputWireFormatName: &RawOutputWireFormat{},
+		EdgeXWireFormatName:     &EdgeXWireFormat{},
+	}
+)
+
+// RegisterWireFormat makes a format available under the given (case insensitive) name
+// for use in the WireFormat setting of any backend.
+func RegisterWireFormat(name string, format WireFormat) error {
+	key := strings.ToLower(strings.TrimSpace(name))
+
+	if key == "" {
+		return fmt.Errorf("wire format name must be specified")
+	}
+
+	if format == nil {
+		return fmt.Errorf("wire format must be specified for %s", name)
+	}
+
+	wireFormatsMutex.Lock()
+	defer wireFormatsMutex.Unlock()
+
+	if _, found := wireFormats[key]; found {
+		return fmt.Errorf("wire format already registered: %s", name)
+	}
+
+	wireFormats[key] = format
+
+	return nil
+}
+
+// LookupWireFormat returns the format registered under the given name, defaulting to
+// EdgeXWireFormat when no name is specified.
+func LookupWireFormat(name string) (WireFormat, error) {
+	key := strings.ToLower(strings.TrimSpace(name))
+
+	if key == "" {
+		key = EdgeXWireFormatName
+	}
+
+	wireFormatsMutex.RLock()
+	format, found := wireFormats[key]
+	wireFormatsMutex.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("invalid wire format specified: '%s' (registered: %s)", name, strings.Join(RegisteredWireFormats(), ", "))
+	}
+
+	return format, nil
+}
+
+// ResolveWireFormat looks up the named wire format for a backend, falling back to edgex with a
+// warning if it is not registered
+func ResolveWireFormat(name string, lc logger.LoggingClient) WireFormat {
+	format, err := LookupWireFormat(name)
+
+	if err != nil {
+		if lc != nil {
+			lc.Warn(fmt.Sprintf("%s, using %s", err.Error(), EdgeXWireFormatName))
+		}
+
+		return &EdgeXWireFormat{}
+	}
+
+	return format
+}
+
+// RegisteredWireFormats returns the sorted names of all registered wire formats.
+func RegisteredWireFormats() []string {
+	wireFormatsMutex.RLock()
+	defer wireFormatsMutex.RUnlock()
+
+	names := make([]string, 0, len(wireFormats))
+
+	for name := range wireFormats {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
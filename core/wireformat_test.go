@@ -0,0 +1,80 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestLookupWireFormat_Builtin(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected WireFormat
+	}{
+		{"", &EdgeXWireFormat{}},
+		{"edgex", &EdgeXWireFormat{}},
+		{"RAW", &RawWireFormat{}},
+		{"rawinput", &RawInputWireFormat{}},
+		{"rawoutput", &RawOutputWireFormat{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := LookupWireFormat(tt.name)
+
+			require.NoError(t, err)
+			require.IsType(t, tt.expected, format)
+		})
+	}
+}
+
+func TestLookupWireFormat_NotRegistered(t *testing.T) {
+	_, err := LookupWireFormat(uuid.NewString())
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), RawWireFormatName, "should list registered formats")
+}
+
+func TestResolveWireFormat_NotRegistered(t *testing.T) {
+	format := ResolveWireFormat(uuid.NewString(), logger.NewMockClient())
+
+	require.IsType(t, &EdgeXWireFormat{}, format, "should fall back to edgex")
+}
+
+func TestRegisterWireFormat(t *testing.T) {
+	name := uuid.NewString()
+	format := &RawWireFormat{}
+
+	require.NoError(t, RegisterWireFormat(strings.ToUpper(name), format))
+
+	found, err := LookupWireFormat(name)
+
+	require.NoError(t, err)
+	require.Same(t, format, found)
+}
+
+func TestRegisterWireFormat_Duplicate(t *testing.T) {
+	require.Error(t, RegisterWireFormat(EdgeXWireFormatName, &RawWireFormat{}))
+}
+
+func TestRegisterWireFormat_Nil(t *testing.T) {
+	require.Error(t, RegisterWireFormat(uuid.NewString(), nil))
+}
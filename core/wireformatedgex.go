@@ -17,6 +17,7 @@
 package core
 
 import (
+	"context"
 	"encoding/json"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
@@ -29,7 +30,7 @@ type EdgeXWireFormat struct {
 	protection dataProtection
 }
 
-func (*EdgeXWireFormat) marshal(envelope types.MessageEnvelope, encrypt binaryModifier) (*message.Message, error) {
+func (*EdgeXWireFormat) Marshal(envelope types.MessageEnvelope, encrypt BinaryModifier) (*message.Message, error) {
 	var pl []byte
 	var err error
 
@@ -60,7 +61,21 @@ func (*EdgeXWireFormat) marshal(envelope types.MessageEnvelope, encrypt binaryMo
 	return msg, nil
 }
 
-func (*EdgeXWireFormat) unmarshal(message *message.Message, decrypt binaryModifier) (types.MessageEnvelope, error) {
+// MarshalContext marshals the envelope, adding W3C trace context from ctx to the message metadata
+func (f *EdgeXWireFormat) MarshalContext(ctx context.Context, envelope types.MessageEnvelope, encrypt BinaryModifier) (*message.Message, error) {
+	msg, err := f.Marshal(envelope, encrypt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	InjectTraceContext(ctx, msg)
+
+	return msg, nil
+}
+
+func (*EdgeXWireFormat) Unmarshal(message *message.Message, decrypt BinaryModifier) (types.MessageEnvelope, error) {
+	ExtractTraceContext(message)
 
 	var err error
 
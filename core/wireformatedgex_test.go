@@ -40,7 +40,7 @@ func TestEdgeXWireFormat_JSON_Marshal(t *testing.T) {
 
 	sut := EdgeXWireFormat{}
 
-	msg, err := sut.marshal(env, nil)
+	msg, err := sut.Marshal(env, nil)
 
 	require.Nil(t, err, "should not return error")
 
@@ -65,7 +65,7 @@ func TestEdgeXWireFormat_JSON_Marshal_Encrypt(t *testing.T) {
 
 	sut := EdgeXWireFormat{}
 
-	msg, err := sut.marshal(env, func(b []byte) ([]byte, error) {
+	msg, err := sut.Marshal(env, func(b []byte) ([]byte, error) {
 		if bytes.Equal(b, jsn) {
 			return enc, nil
 		}
@@ -94,7 +94,7 @@ func TestEdgeXWireFormat_JSON_Unmarshal(t *testing.T) {
 
 	sut := EdgeXWireFormat{}
 
-	result, err := sut.unmarshal(msg, nil)
+	result, err := sut.Unmarshal(msg, nil)
 
 	require.Nil(t, err, "should not return error")
 	require.NotNil(t, result, "should return result")
@@ -117,7 +117,7 @@ func TestEdgeXWireFormat_JSON_Unmarshal_Decrypt(t *testing.T) {
 
 	sut := EdgeXWireFormat{}
 
-	result, err := sut.unmarshal(msg, func(b []byte) ([]byte, error) {
+	result, err := sut.Unmarshal(msg, func(b []byte) ([]byte, error) {
 		if bytes.Equal(b, enc) {
 			return jsn, nil
 		}
@@ -137,7 +137,7 @@ func TestEdgeXWireFormat_JSON_Unmarshal_JSONError(t *testing.T) {
 
 	sut := EdgeXWireFormat{}
 
-	result, err := sut.unmarshal(msg, nil)
+	result, err := sut.Unmarshal(msg, nil)
 
 	require.NotNil(t, err, "should return error")
 	require.Zero(t, result, "should not return result")
@@ -17,26 +17,35 @@
 package core
 
 import (
+	"context"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/edgexfoundry/go-mod-messaging/v2/pkg/types"
 )
 
 type RawInputWireFormat struct{}
 
-func (*RawInputWireFormat) marshal(envelope types.MessageEnvelope, encryptor binaryModifier) (*message.Message, error) {
-	return (&EdgeXWireFormat{}).marshal(envelope, encryptor)
+func (*RawInputWireFormat) Marshal(envelope types.MessageEnvelope, encryptor BinaryModifier) (*message.Message, error) {
+	return (&EdgeXWireFormat{}).Marshal(envelope, encryptor)
 }
 
-func (*RawInputWireFormat) unmarshal(msg *message.Message, decryptor binaryModifier) (types.MessageEnvelope, error) {
-	return (&RawWireFormat{}).unmarshal(msg, decryptor)
+func (*RawInputWireFormat) MarshalContext(ctx context.Context, envelope types.MessageEnvelope, encryptor BinaryModifier) (*message.Message, error) {
+	return (&EdgeXWireFormat{}).MarshalContext(ctx, envelope, encryptor)
+}
+
+func (*RawInputWireFormat) Unmarshal(msg *message.Message, decryptor BinaryModifier) (types.MessageEnvelope, error) {
+	return (&RawWireFormat{}).Unmarshal(msg, decryptor)
 }
 
 type RawOutputWireFormat struct{}
 
-func (*RawOutputWireFormat) marshal(envelope types.MessageEnvelope, encryptor binaryModifier) (*message.Message, error) {
-	return (&RawWireFormat{}).marshal(envelope, encryptor)
+func (*RawOutputWireFormat) Marshal(envelope types.MessageEnvelope, encryptor BinaryModifier) (*message.Message, error) {
+	return (&RawWireFormat{}).Marshal(envelope, encryptor)
+}
+
+func (*RawOutputWireFormat) MarshalContext(ctx context.Context, envelope types.MessageEnvelope, encryptor BinaryModifier) (*message.Message, error) {
+	return (&RawWireFormat{}).MarshalContext(ctx, envelope, encryptor)
 }
 
-func (*RawOutputWireFormat) unmarshal(msg *message.Message, decryptor binaryModifier) (types.MessageEnvelope, error) {
-	return (&EdgeXWireFormat{}).unmarshal(msg, decryptor)
+func (*RawOutputWireFormat) Unmarshal(msg *message.Message, decryptor BinaryModifier) (types.MessageEnvelope, error) {
+	return (&EdgeXWireFormat{}).Unmarshal(msg, decryptor)
 }
@@ -17,6 +17,7 @@
 package core
 
 import (
+	"context"
 	"github.com/ThreeDotsLabs/watermill/message"
 	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 	"github.com/edgexfoundry/go-mod-core-contracts/v2/common"
@@ -26,7 +27,7 @@ import (
 
 type RawWireFormat struct{}
 
-func (*RawWireFormat) marshal(envelope types.MessageEnvelope, encrypt binaryModifier) (*message.Message, error) {
+func (*RawWireFormat) Marshal(envelope types.MessageEnvelope, encrypt BinaryModifier) (*message.Message, error) {
 	correlationID := envelope.CorrelationID
 
 	if correlationID == "" {
@@ -52,7 +53,22 @@ func (*RawWireFormat) marshal(envelope types.MessageEnvelope, encrypt binaryModi
 	return m, nil
 }
 
-func (*RawWireFormat) unmarshal(msg *message.Message, decrypt binaryModifier) (types.MessageEnvelope, error) {
+// MarshalContext marshals the envelope, adding W3C trace context from ctx to the message metadata
+func (f *RawWireFormat) MarshalContext(ctx context.Context, envelope types.MessageEnvelope, encrypt BinaryModifier) (*message.Message, error) {
+	msg, err := f.Marshal(envelope, encrypt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	InjectTraceContext(ctx, msg)
+
+	return msg, nil
+}
+
+func (*RawWireFormat) Unmarshal(msg *message.Message, decrypt BinaryModifier) (types.MessageEnvelope, error) {
+	ExtractTraceContext(msg)
+
 	correlationID := msg.Metadata.Get(middleware.CorrelationIDMetadataKey)
 
 	if correlationID == "" {
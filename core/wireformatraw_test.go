@@ -37,7 +37,7 @@ func TestRawMessageFormat_Marshal_HasCorrelationID(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	msg, err := sut.marshal(env, nil)
+	msg, err := sut.Marshal(env, nil)
 
 	require.Nil(t, err, "should not return error")
 
@@ -58,7 +58,7 @@ func TestRawMessageFormat_Marshal_NoCorrelationId(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	msg, err := sut.marshal(env, nil)
+	msg, err := sut.Marshal(env, nil)
 
 	require.Nil(t, err, "should not return error")
 
@@ -81,7 +81,7 @@ func TestRawMessageFormat_Marshal_Encrypt(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	msg, err := sut.marshal(env, func(b []byte) ([]byte, error) {
+	msg, err := sut.Marshal(env, func(b []byte) ([]byte, error) {
 		if bytes.Equal(b, env.Payload) {
 			return enc, nil
 		}
@@ -107,7 +107,7 @@ func TestRawMessageFormat_Unmarshal_HasCorrelationId(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	env, err := sut.unmarshal(msg, nil)
+	env, err := sut.Unmarshal(msg, nil)
 
 	require.Nil(t, err, "should not return error")
 
@@ -127,7 +127,7 @@ func TestRawMessageFormat_Unmarshal_HasMessageID(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	env, err := sut.unmarshal(msg, nil)
+	env, err := sut.Unmarshal(msg, nil)
 
 	require.Nil(t, err, "should not return error")
 
@@ -151,7 +151,7 @@ func TestRawMessageFormat_Unmarshal_Decrypt(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	env, err := sut.unmarshal(msg, func(b []byte) ([]byte, error) {
+	env, err := sut.Unmarshal(msg, func(b []byte) ([]byte, error) {
 		if bytes.Equal(b, enc) {
 			return pl, nil
 		}
@@ -173,7 +173,7 @@ func TestRawMessageFormat_Marshal_HasNoID(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	env, err := sut.unmarshal(msg, nil)
+	env, err := sut.Unmarshal(msg, nil)
 
 	require.Nil(t, err, "should not return error")
 
@@ -190,7 +190,7 @@ func TestRawMessageFormat_Unmarshal_InfersCBORByDefault(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	env, err := sut.unmarshal(msg, nil)
+	env, err := sut.Unmarshal(msg, nil)
 
 	require.Nil(t, err, "should not return error")
 
@@ -207,7 +207,7 @@ func TestRawMessageFormat_Unmarshal_InfersJSONForObject(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	env, err := sut.unmarshal(msg, nil)
+	env, err := sut.Unmarshal(msg, nil)
 
 	require.Nil(t, err, "should not return error")
 
@@ -224,7 +224,7 @@ func TestRawMessageFormat_Unmarshal_InfersJSONForArray(t *testing.T) {
 
 	sut := RawWireFormat{}
 
-	env, err := sut.unmarshal(msg, nil)
+	env, err := sut.Unmarshal(msg, nil)
 
 	require.Nil(t, err, "should not return error")
 
@@ -0,0 +1,57 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+)
+
+// workerPool limits the number of messages processed concurrently.  A pool without
+// slots places no limit on concurrency.
+type workerPool struct {
+	slots chan struct{}
+}
+
+func newWorkerPool(size int) *workerPool {
+	pool := &workerPool{}
+
+	if size > 0 {
+		pool.slots = make(chan struct{}, size)
+	}
+
+	return pool
+}
+
+// acquire blocks until a worker is available, returning false if the context is done first
+func (wp *workerPool) acquire(ctx context.Context) bool {
+	if wp.slots == nil {
+		return true
+	}
+
+	select {
+	case wp.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (wp *workerPool) release() {
+	if wp.slots != nil {
+		<-wp.slots
+	}
+}
@@ -0,0 +1,47 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package core
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestWorkerPool_Unbounded(t *testing.T) {
+	pool := newWorkerPool(0)
+
+	for i := 0; i < 100; i++ {
+		require.True(t, pool.acquire(context.Background()))
+	}
+}
+
+func TestWorkerPool_Bounded(t *testing.T) {
+	pool := newWorkerPool(2)
+
+	require.True(t, pool.acquire(context.Background()))
+	require.True(t, pool.acquire(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.False(t, pool.acquire(ctx), "should not acquire while all workers busy")
+
+	pool.release()
+
+	require.True(t, pool.acquire(context.Background()))
+}
@@ -1,19 +1,46 @@
 package edgex_watermill
 
 import (
+	"context"
 	"fmt"
-	"github.com/alexcuse/edgex-watermill/v2/amqp"
 	"github.com/alexcuse/edgex-watermill/v2/core"
-	"github.com/alexcuse/edgex-watermill/v2/googlecloud"
-	"github.com/alexcuse/edgex-watermill/v2/jetstream"
-	"github.com/alexcuse/edgex-watermill/v2/kafka"
-	"github.com/alexcuse/edgex-watermill/v2/nats"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
-	"strings"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
+	"net/http"
+
+	// builtin backends register themselves with core on import
+	_ "github.com/alexcuse/edgex-watermill/v2/amqp"
+	_ "github.com/alexcuse/edgex-watermill/v2/googlecloud"
+	_ "github.com/alexcuse/edgex-watermill/v2/jetstream"
+	_ "github.com/alexcuse/edgex-watermill/v2/kafka"
+	_ "github.com/alexcuse/edgex-watermill/v2/nats"
+	_ "github.com/alexcuse/edgex-watermill/v2/natscore"
 )
 
 func Register(service interfaces.ApplicationService) {
-	service.RegisterCustomTriggerFactory("watermill", buildTrigger)
+	core.SetSecretProvider(service)
+
+	service.RegisterCustomTriggerFactory("watermill", func(config interfaces.TriggerConfig) (interfaces.Trigger, error) {
+		trigger, err := buildTrigger(config)
+
+		if err != nil {
+			return nil, err
+		}
+
+		watchConfig(service, trigger)
+
+		return trigger, nil
+	})
+
+	// metrics are only recorded when enabled in config, an empty registry is served otherwise
+	if err := service.AddRoute(core.MetricsRoute, core.MetricsHandler().ServeHTTP, http.MethodGet); err != nil {
+		service.LoggingClient().Error(fmt.Sprintf("Failed to add metrics route: %s", err.Error()))
+	}
+
+	if err := service.AddRoute(core.HealthRoute, core.HealthHandler().ServeHTTP, http.MethodGet); err != nil {
+		service.LoggingClient().Error(fmt.Sprintf("Failed to add health route: %s", err.Error()))
+	}
 }
 
 func buildTrigger(config interfaces.TriggerConfig) (interfaces.Trigger, error) {
@@ -25,18 +52,74 @@ func buildTrigger(config interfaces.TriggerConfig) (interfaces.Trigger, error) {
 		return nil, err
 	}
 
-	switch strings.ToLower(cfg.WatermillTrigger.Type) {
-	case "nats":
-		return nats.Trigger(cfg, config)
-	case "jetstream":
-		return jetstream.Trigger(cfg, config)
-	case "kafka":
-		return kafka.Trigger(cfg, config)
-	case "amqp":
-		return amqp.Trigger(cfg, config)
-	case "googlecloud":
-		return googlecloud.Trigger(cfg, config)
-	default:
-		return nil, fmt.Errorf("Invalid Trigger Type Specified: %s", cfg.WatermillTrigger.Type)
+	backend, err := core.LookupBackend(cfg.WatermillTrigger.Type)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if backend.Trigger == nil {
+		return nil, fmt.Errorf("backend '%s' does not support triggers", cfg.WatermillTrigger.Type)
+	}
+
+	return backend.Trigger(cfg, config)
+}
+
+// watchConfig applies changes to the WatermillTrigger section from the configuration provider
+// to a running trigger.  Without a provider configuration is only read at startup.
+func watchConfig(service interfaces.ApplicationService, trigger interfaces.Trigger) {
+	reconfigurable, ok := trigger.(core.ReconfigurableTrigger)
+
+	if !ok {
+		return
+	}
+
+	lc := service.LoggingClient()
+
+	err := service.ListenForCustomConfigChanges(&core.WatermillConfigWrapper{}, "WatermillTrigger", func(rawConfig interface{}) {
+		updated := &core.WatermillConfigWrapper{}
+
+		if !updated.UpdateFromRaw(rawConfig) {
+			lc.Error("Unable to apply WatermillTrigger update, unexpected configuration type")
+			return
+		}
+
+		if err := reconfigurable.Reconfigure(updated.WatermillTrigger); err != nil {
+			lc.Error(fmt.Sprintf("Failed to apply WatermillTrigger update: %s", err.Error()))
+		}
+	})
+
+	if err != nil {
+		lc.Warn(fmt.Sprintf("Unable to watch WatermillTrigger configuration for changes: %s", err.Error()))
+	}
+}
+
+// Client builds a messaging client for any registered backend based on config.Type, logging through lc
+func Client(ctx context.Context, config core.WatermillConfig, lc logger.LoggingClient) (messaging.MessageClient, error) {
+	backend, err := core.LookupBackend(config.Type)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if backend.Client == nil {
+		return nil, fmt.Errorf("backend '%s' does not support clients", config.Type)
 	}
+
+	return backend.Client(ctx, config, lc)
+}
+
+// Sender builds a pipeline sender for any registered backend based on config.Type, logging through lc
+func Sender(config core.WatermillConfig, proceed bool, lc logger.LoggingClient) (core.WatermillSender, error) {
+	backend, err := core.LookupBackend(config.Type)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if backend.Sender == nil {
+		return nil, fmt.Errorf("backend '%s' does not support senders", config.Type)
+	}
+
+	return backend.Sender(config, proceed, lc)
 }
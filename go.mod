@@ -12,6 +12,7 @@ require (
 	github.com/ThreeDotsLabs/watermill-googlecloud v1.0.9
 	github.com/ThreeDotsLabs/watermill-kafka/v2 v2.2.0
 	github.com/ThreeDotsLabs/watermill-nats v1.0.5
+	github.com/cenkalti/backoff/v3 v3.2.2
 	github.com/edgexfoundry/app-functions-sdk-go/v2 v2.2.0
 	github.com/edgexfoundry/go-mod-bootstrap/v2 v2.2.0
 	github.com/edgexfoundry/go-mod-core-contracts/v2 v2.2.0
@@ -22,11 +23,21 @@ require (
 	github.com/hashicorp/go-multierror v1.1.1
 	github.com/nats-io/jwt v1.2.2 // indirect
 	github.com/nats-io/nats.go v1.13.1-0.20220202232944-a0a6a71ede98
+	github.com/nats-io/nkeys v0.3.0
 	github.com/nats-io/stan.go v0.8.3
+	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
 	github.com/streadway/amqp v1.0.0 // indirect
 	github.com/stretchr/objx v0.2.0 // indirect
 	github.com/stretchr/testify v1.7.1
+	github.com/xdg-go/scram v1.0.2
+	go.etcd.io/bbolt v1.3.6
 	go.opencensus.io v0.23.0 // indirect
+	go.opentelemetry.io/otel v1.0.1
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.0.1
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.0.1
+	go.opentelemetry.io/otel/sdk v1.0.1
+	go.opentelemetry.io/otel/trace v1.0.1
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616 // indirect
 	golang.org/x/tools v0.1.2 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
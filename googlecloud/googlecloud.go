@@ -19,67 +19,71 @@ package googlecloud
 import (
 	"cloud.google.com/go/pubsub"
 	"context"
-	"github.com/ThreeDotsLabs/watermill"
 	gcp "github.com/ThreeDotsLabs/watermill-googlecloud/pkg/googlecloud"
 	"github.com/ThreeDotsLabs/watermill/message"
 	ewm "github.com/alexcuse/edgex-watermill/v2/core"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
 	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
-	"os"
-	"strings"
 )
 
-func Sender(config ewm.WatermillConfig, proceed bool) (ewm.WatermillSender, error) {
-	pub, err := Publisher(config)
+const backendName = "googlecloud"
+
+func init() {
+	err := ewm.RegisterBackend(backendName, ewm.Backend{
+		Publisher:  Publisher,
+		Subscriber: Subscriber,
+		Trigger:    Trigger,
+		Client:     Client,
+		Sender:     Sender,
+	})
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func Sender(config ewm.WatermillConfig, proceed bool, lc logger.LoggingClient) (ewm.WatermillSender, error) {
+	pub, err := Publisher(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return ewm.NewWatermillSender(
+	return ewm.NewWatermillSenderWithLogger(
 		pub,
 		proceed,
 		&config,
+		lc,
 	)
 }
 
-func Client(ctx context.Context, config ewm.WatermillConfig) (messaging.MessageClient, error) {
-	pub, err := Publisher(config)
+func Client(ctx context.Context, config ewm.WatermillConfig, lc logger.LoggingClient) (messaging.MessageClient, error) {
+	format := ewm.ResolveWireFormat(config.WireFormat, lc)
+
+	pub, err := Publisher(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	sub, err := Subscriber(config)
+	sub, err := Subscriber(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
-	var fmt ewm.WireFormat
-
-	switch strings.ToLower(config.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
-	}
 
-	return ewm.NewWatermillClient(
+	return ewm.NewWatermillClientWithLogger(
 		ctx,
 		pub,
 		sub,
-		fmt,
+		format,
 		&config,
+		lc,
 	)
 }
 
-func Publisher(config ewm.WatermillConfig) (message.Publisher, error) {
+func Publisher(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
 	return gcp.NewPublisher(gcp.PublisherConfig{
 		ProjectID:                 config.ClientId,
 		DoNotCreateTopicIfMissing: false,
@@ -88,10 +92,10 @@ func Publisher(config ewm.WatermillConfig) (message.Publisher, error) {
 		PublishSettings:           nil,
 		ClientOptions:             nil,
 		Marshaler:                 nil,
-	}, watermill.NewStdLoggerWithOut(os.Stdout, true, false))
+	}, ewm.NewBackendLogAdapter(lc, backendName))
 }
 
-func Subscriber(config ewm.WatermillConfig) (message.Subscriber, error) {
+func Subscriber(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error) {
 	return gcp.NewSubscriber(gcp.SubscriberConfig{
 		GenerateSubscriptionName:         nil,
 		ProjectID:                        config.ClientId,
@@ -104,41 +108,28 @@ func Subscriber(config ewm.WatermillConfig) (message.Subscriber, error) {
 		SubscriptionConfig:               pubsub.SubscriptionConfig{},
 		ClientOptions:                    nil,
 		Unmarshaler:                      nil,
-	}, watermill.NewStdLoggerWithOut(os.Stdout, true, false))
+	}, ewm.NewBackendLogAdapter(lc, backendName))
 }
 
 func Trigger(wc *ewm.WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error) {
-	pub, err := Publisher(wc.WatermillTrigger)
+	format := ewm.ResolveWireFormat(wc.WatermillTrigger.WireFormat, cfg.Logger)
+
+	pub, err := Publisher(wc.WatermillTrigger, cfg.Logger)
 
 	if err != nil {
 		return nil, err
 	}
 
-	sub, err := Subscriber(wc.WatermillTrigger)
+	sub, err := Subscriber(wc.WatermillTrigger, cfg.Logger)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var fmt ewm.WireFormat
-
-	switch strings.ToLower(wc.WatermillTrigger.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
-	}
-
 	return ewm.NewWatermillTrigger(
 		pub,
 		sub,
-		fmt,
+		format,
 		wc,
 		cfg,
 	)
@@ -18,129 +18,215 @@ package jetstream
 
 import (
 	"context"
+	"fmt"
 	_nats "github.com/AlexCuse/watermill-jetstream/pkg/jetstream"
-	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill/message"
 	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/alexcuse/edgex-watermill/v2/natscommon"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
 	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
 	"github.com/nats-io/nats.go"
-	"os"
-	"strings"
-	"time"
 )
 
-func Sender(config ewm.WatermillConfig, proceed bool) (ewm.WatermillSender, error) {
-	pub, err := Publisher(config)
+const backendName = "jetstream"
+
+func init() {
+	err := ewm.RegisterBackend(backendName, ewm.Backend{
+		Publisher:  Publisher,
+		Subscriber: Subscriber,
+		Trigger:    Trigger,
+		Client:     Client,
+		Sender:     Sender,
+	})
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func Sender(config ewm.WatermillConfig, proceed bool, lc logger.LoggingClient) (ewm.WatermillSender, error) {
+	pub, err := Publisher(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return ewm.NewWatermillSender(
+	return ewm.NewWatermillSenderWithLogger(
 		pub,
 		proceed,
 		&config,
+		lc,
 	)
 }
 
-func Client(ctx context.Context, config ewm.WatermillConfig) (messaging.MessageClient, error) {
-	pub, err := Publisher(config)
+func Client(ctx context.Context, config ewm.WatermillConfig, lc logger.LoggingClient) (messaging.MessageClient, error) {
+	format := ewm.ResolveWireFormat(config.WireFormat, lc)
+
+	pub, err := Publisher(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	sub, err := Subscriber(config)
+	sub, err := Subscriber(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var fmt ewm.WireFormat
-
-	switch strings.ToLower(config.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
-	}
-
-	return ewm.NewWatermillClient(
+	return ewm.NewWatermillClientWithLogger(
 		ctx,
 		pub,
 		sub,
-		fmt,
+		format,
 		&config,
+		lc,
 	)
 }
 
-func Publisher(config ewm.WatermillConfig) (message.Publisher, error) {
-	return _nats.NewPublisher(_nats.PublisherConfig{
-		URL:           config.BrokerUrl,
-		Marshaler:     _nats.GobMarshaler{},
-		NatsOptions:   defaultNatsOptions(),
-		AutoProvision: true,
-	}, watermill.NewStdLoggerWithOut(os.Stdout, true, false))
-}
+func Publisher(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
+	marshaler, err := marshalerType(config)
 
-func Subscriber(config ewm.WatermillConfig) (message.Subscriber, error) {
-	return _nats.NewSubscriber(_nats.SubscriberConfig{
-		URL:         config.BrokerUrl,
-		ClientID:    config.ClientId,
-		DurableName: config.Optional["durablename"],
-		QueueGroup:  config.Optional["queuegroup"],
-		NatsOptions: defaultNatsOptions(),
-		//TODO
-		SubscribeOptions: []nats.SubOpt{nats.DeliverNew()},
-		Unmarshaler:      _nats.GobMarshaler{},
-		AutoProvision:    true,
-	}, watermill.NewStdLoggerWithOut(os.Stdout, true, false))
-}
+	if err != nil {
+		return nil, err
+	}
+
+	streams, provision, err := newStreamSettings(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := natscommon.Connect(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var pub message.Publisher
+
+	if marshaler == natscommon.MarshalerHeaders {
+		streams = defaultStreams(streams, provision)
+
+		pub, err = newHeaderPublisher(conn)
+	} else {
+		pub, err = _nats.NewPublisherWithNatsConn(conn, _nats.PublisherPublishConfig{
+			Marshaler:     _nats.GobMarshaler{},
+			AutoProvision: provision && streams == nil,
+		}, ewm.NewBackendLogAdapter(lc, backendName))
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if streams == nil {
+		return pub, nil
+	}
+
+	p, err := newProvisioner(conn, streams)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
 
-func defaultNatsOptions() []nats.Option {
-	//TODO
-	return []nats.Option{nats.Timeout(5 * time.Second), nats.ReconnectWait(time.Second)}
+	return &provisioningPublisher{Publisher: pub, provisioner: p}, nil
 }
 
-func Trigger(wc *ewm.WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error) {
-	pub, err := Publisher(wc.WatermillTrigger)
+func Subscriber(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error) {
+	marshaler, err := marshalerType(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	consumer, err := newConsumerSettings(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	streams, provision, err := newStreamSettings(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := natscommon.Connect(config, subscriberConnectionName(config)...)
 
 	if err != nil {
 		return nil, err
 	}
 
-	sub, err := Subscriber(wc.WatermillTrigger)
+	var sub message.Subscriber
+
+	if consumer.pull {
+		streams = defaultStreams(streams, provision)
+
+		sub, err = newPullSubscriber(conn, consumer, unmarshaler(marshaler), ewm.NewBackendLogAdapter(lc, backendName))
+	} else {
+		sub, err = _nats.NewSubscriberWithNatsConn(conn, _nats.SubscriberSubscriptionConfig{
+			DurableName:      consumer.durableName,
+			QueueGroup:       consumer.queueGroup,
+			AckWaitTimeout:   consumer.ackWait,
+			SubscribeOptions: consumer.subscribeOptions(),
+			Unmarshaler:      unmarshaler(marshaler),
+			AutoProvision:    provision && streams == nil,
+		}, ewm.NewBackendLogAdapter(lc, backendName))
+	}
 
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	var fmt ewm.WireFormat
+	if streams == nil {
+		return sub, nil
+	}
 
-	switch strings.ToLower(wc.WatermillTrigger.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
+	p, err := newProvisioner(conn, streams)
+
+	if err != nil {
+		_ = sub.Close()
+		return nil, err
+	}
+
+	return &provisioningSubscriber{Subscriber: sub, provisioner: p}, nil
+}
+
+// subscriberConnectionName names the subscriber's connection for ClientId, as the nats backend
+// does.  watermill-jetstream has nowhere else to use it, consumers are identified by
+// Optional.DurableName.
+func subscriberConnectionName(config ewm.WatermillConfig) []nats.Option {
+	if config.ClientId == "" {
+		return nil
+	}
+
+	return []nats.Option{nats.Name(fmt.Sprintf("sub-%s", config.ClientId))}
+}
+
+func Trigger(wc *ewm.WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error) {
+	format := ewm.ResolveWireFormat(wc.WatermillTrigger.WireFormat, cfg.Logger)
+
+	pub, err := Publisher(wc.WatermillTrigger, cfg.Logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := Subscriber(wc.WatermillTrigger, cfg.Logger)
+
+	if err != nil {
+		return nil, err
 	}
 
 	return ewm.NewWatermillTrigger(
 		pub,
 		sub,
-		fmt,
+		format,
 		wc,
 		cfg,
 	)
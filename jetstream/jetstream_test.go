@@ -0,0 +1,190 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jetstream
+
+import (
+	_nats "github.com/AlexCuse/watermill-jetstream/pkg/jetstream"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/alexcuse/edgex-watermill/v2/natscommon"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestNewConsumerSettings_Defaults(t *testing.T) {
+	settings, err := newConsumerSettings(ewm.WatermillConfig{Optional: map[string]string{"durablename": "durable", "queuegroup": "group"}})
+
+	require.NoError(t, err)
+	require.Equal(t, "durable", settings.durableName)
+	require.Equal(t, "group", settings.queueGroup)
+	require.False(t, settings.pull)
+	require.Equal(t, nats.DeliverNewPolicy, settings.deliverPolicy, "should keep delivering new messages by default")
+	require.Len(t, settings.subscribeOptions(), 1)
+}
+
+func TestNewConsumerSettings(t *testing.T) {
+	start := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	settings, err := newConsumerSettings(ewm.WatermillConfig{Optional: map[string]string{
+		OptionConsumerType:     "Pull",
+		OptionDurableName:      "durable",
+		OptionPullBatchSize:    "50",
+		OptionDeliverPolicy:    "time",
+		OptionDeliverStartTime: start.Format(time.RFC3339),
+		OptionAckWait:          "45s",
+		OptionMaxDeliver:       "5",
+		OptionMaxAckPending:    "100",
+	}})
+
+	require.NoError(t, err)
+	require.True(t, settings.pull)
+	require.Equal(t, 50, settings.pullBatchSize)
+
+	cc := settings.consumerConfig("topic.*")
+
+	require.Equal(t, "durable", cc.Durable)
+	require.Equal(t, "topic.*", cc.FilterSubject)
+	require.Equal(t, nats.DeliverByStartTimePolicy, cc.DeliverPolicy)
+	require.Equal(t, start, *cc.OptStartTime)
+	require.Equal(t, nats.AckExplicitPolicy, cc.AckPolicy)
+	require.Equal(t, 45*time.Second, cc.AckWait)
+	require.Equal(t, 5, cc.MaxDeliver)
+	require.Equal(t, 100, cc.MaxAckPending)
+
+	opts := settings.subscribeOptions()
+
+	require.Len(t, opts, 4)
+	require.Equal(t, len(opts), cap(opts), "appending should not share the backing array")
+}
+
+func TestNewConsumerSettings_StartSequence(t *testing.T) {
+	settings, err := newConsumerSettings(ewm.WatermillConfig{Optional: map[string]string{OptionDeliverPolicy: "sequence", OptionDeliverStartSequence: "42"}})
+
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), settings.consumerConfig("topic.*").OptStartSeq)
+}
+
+func TestNewConsumerSettings_Invalid(t *testing.T) {
+	invalid := []map[string]string{
+		{OptionConsumerType: "poll"},
+		{OptionConsumerType: "pull"},
+		{OptionConsumerType: "pull", OptionDurableName: "durable", OptionQueueGroup: "group"},
+		{OptionPullBatchSize: "-1"},
+		{OptionDeliverPolicy: "first"},
+		{OptionDeliverPolicy: "sequence"},
+		{OptionDeliverPolicy: "time", OptionDeliverStartTime: "yesterday"},
+		{OptionAckWait: "30"},
+		{OptionMaxDeliver: "many"},
+		{OptionMaxAckPending: "many"},
+	}
+
+	for _, optional := range invalid {
+		_, err := newConsumerSettings(ewm.WatermillConfig{Optional: optional})
+		require.Error(t, err, optional)
+	}
+}
+
+func TestNewStreamSettings(t *testing.T) {
+	settings, provision, err := newStreamSettings(ewm.WatermillConfig{})
+
+	require.NoError(t, err)
+	require.True(t, provision, "should provision by default")
+	require.Nil(t, settings, "should leave provisioning to watermill-jetstream by default")
+
+	settings, provision, err = newStreamSettings(ewm.WatermillConfig{Optional: map[string]string{OptionAutoProvision: "false", OptionStreamReplicas: "3"}})
+
+	require.NoError(t, err)
+	require.False(t, provision)
+	require.Nil(t, settings)
+
+	settings, provision, err = newStreamSettings(ewm.WatermillConfig{Optional: map[string]string{
+		OptionStreamSubjects:  "{topic}.*, {topic}-archive.>",
+		OptionStreamRetention: "WorkQueue",
+		OptionStreamStorage:   "memory",
+		OptionStreamReplicas:  "3",
+		OptionStreamMaxAge:    "24h",
+	}})
+
+	require.NoError(t, err)
+	require.True(t, provision)
+
+	sc := settings.streamConfig("events")
+
+	require.Equal(t, "events", sc.Name)
+	require.Equal(t, []string{"events.*", "events-archive.>"}, sc.Subjects)
+	require.Equal(t, nats.WorkQueuePolicy, sc.Retention)
+	require.Equal(t, nats.MemoryStorage, sc.Storage)
+	require.Equal(t, 3, sc.Replicas)
+	require.Equal(t, 24*time.Hour, sc.MaxAge)
+
+	settings, _, err = newStreamSettings(ewm.WatermillConfig{Optional: map[string]string{OptionStreamMaxAge: "1h"}})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"events.*"}, settings.streamConfig("events").Subjects, "should capture published subjects by default")
+}
+
+func TestDefaultStreams(t *testing.T) {
+	require.NotNil(t, defaultStreams(nil, true), "should provision with defaults when auto provisioning")
+	require.Nil(t, defaultStreams(nil, false))
+
+	configured := &streamSettings{replicas: 3}
+
+	require.Same(t, configured, defaultStreams(configured, true))
+}
+
+func TestNewStreamSettings_Invalid(t *testing.T) {
+	invalid := []map[string]string{
+		{OptionAutoProvision: "sometimes"},
+		{OptionStreamRetention: "forever"},
+		{OptionStreamStorage: "tape"},
+		{OptionStreamReplicas: "three"},
+		{OptionStreamMaxAge: "1 day"},
+	}
+
+	for _, optional := range invalid {
+		_, _, err := newStreamSettings(ewm.WatermillConfig{Optional: optional})
+		require.Error(t, err, optional)
+	}
+}
+
+func TestUnmarshaler(t *testing.T) {
+	require.IsType(t, _nats.GobMarshaler{}, unmarshaler(natscommon.MarshalerGob))
+	require.IsType(t, natscommon.HeaderMarshaler{}, unmarshaler(natscommon.MarshalerHeaders))
+}
+
+func TestInvalidMarshaler(t *testing.T) {
+	config := ewm.WatermillConfig{Optional: map[string]string{OptionMarshaler: "json"}}
+
+	_, err := Publisher(config, nil)
+	require.Error(t, err)
+
+	_, err = Subscriber(config, nil)
+	require.Error(t, err)
+}
+
+func TestSubscriberConnectionName(t *testing.T) {
+	require.Empty(t, subscriberConnectionName(ewm.WatermillConfig{}))
+
+	opts := nats.GetDefaultOptions()
+
+	for _, opt := range subscriberConnectionName(ewm.WatermillConfig{ClientId: "service"}) {
+		require.NoError(t, opt(&opts))
+	}
+
+	require.Equal(t, "sub-service", opts.Name)
+}
@@ -0,0 +1,82 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jetstream
+
+import (
+	"fmt"
+	_nats "github.com/AlexCuse/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill/message"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/alexcuse/edgex-watermill/v2/natscommon"
+	"github.com/nats-io/nats.go"
+)
+
+// OptionMarshaler selects gob (default) or headers encoding, see natscommon.MarshalerType
+const OptionMarshaler = natscommon.OptionMarshaler
+
+func marshalerType(config ewm.WatermillConfig) (string, error) {
+	return natscommon.MarshalerType(config, natscommon.MarshalerGob)
+}
+
+func unmarshaler(marshaler string) _nats.Unmarshaler {
+	if marshaler == natscommon.MarshalerHeaders {
+		return natscommon.HeaderMarshaler{}
+	}
+
+	return _nats.GobMarshaler{}
+}
+
+// headerPublisher publishes messages with their metadata in NATS headers, which the
+// watermill-jetstream publisher cannot send.  Subjects match those used by watermill-jetstream
+// ("{topic}.{uuid}") so streams and subscribers work with either.
+type headerPublisher struct {
+	conn      *nats.Conn
+	js        nats.JetStreamContext
+	marshaler natscommon.HeaderMarshaler
+}
+
+func newHeaderPublisher(conn *nats.Conn) (*headerPublisher, error) {
+	js, err := conn.JetStream()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &headerPublisher{conn: conn, js: js}, nil
+}
+
+func (hp *headerPublisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		natsMsg, err := hp.marshaler.Marshal(fmt.Sprintf("%s.%s", topic, msg.UUID), msg)
+
+		if err != nil {
+			return err
+		}
+
+		if _, err = hp.js.PublishMsg(natsMsg); err != nil {
+			return fmt.Errorf("cannot publish message %s to %s: %s", msg.UUID, topic, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (hp *headerPublisher) Close() error {
+	hp.conn.Close()
+
+	return nil
+}
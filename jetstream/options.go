@@ -0,0 +1,327 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jetstream
+
+import (
+	"fmt"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/util"
+	"github.com/nats-io/nats.go"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Optional settings read from WatermillConfig.Optional
+const (
+	OptionDurableName   = "DurableName"
+	OptionQueueGroup    = "QueueGroup"
+	OptionConsumerType  = "ConsumerType"
+	OptionPullBatchSize = "PullBatchSize"
+
+	OptionDeliverPolicy        = "DeliverPolicy"
+	OptionDeliverStartSequence = "DeliverStartSequence"
+	OptionDeliverStartTime     = "DeliverStartTime"
+	OptionAckWait              = "AckWait"
+	OptionMaxDeliver           = "MaxDeliver"
+	OptionMaxAckPending        = "MaxAckPending"
+
+	OptionAutoProvision   = "AutoProvision"
+	OptionStreamSubjects  = "StreamSubjects"
+	OptionStreamRetention = "StreamRetention"
+	OptionStreamStorage   = "StreamStorage"
+	OptionStreamReplicas  = "StreamReplicas"
+	OptionStreamMaxAge    = "StreamMaxAge"
+)
+
+const (
+	ConsumerTypePush = "push"
+	ConsumerTypePull = "pull"
+
+	// TopicPlaceholder in StreamSubjects is replaced by the topic the stream is created for
+	TopicPlaceholder = "{topic}"
+)
+
+const (
+	defaultPullBatchSize = 10
+)
+
+var deliverPolicies = map[string]nats.DeliverPolicy{
+	"all":      nats.DeliverAllPolicy,
+	"last":     nats.DeliverLastPolicy,
+	"new":      nats.DeliverNewPolicy,
+	"sequence": nats.DeliverByStartSequencePolicy,
+	"time":     nats.DeliverByStartTimePolicy,
+}
+
+var retentionPolicies = map[string]nats.RetentionPolicy{
+	"limits":    nats.LimitsPolicy,
+	"interest":  nats.InterestPolicy,
+	"workqueue": nats.WorkQueuePolicy,
+}
+
+var storageTypes = map[string]nats.StorageType{
+	"file":   nats.FileStorage,
+	"memory": nats.MemoryStorage,
+}
+
+// consumerSettings describes the JetStream consumer created for a subscription
+type consumerSettings struct {
+	durableName   string
+	queueGroup    string
+	pull          bool
+	pullBatchSize int
+	deliverPolicy nats.DeliverPolicy
+	startSequence uint64
+	startTime     time.Time
+	ackWait       time.Duration
+	maxDeliver    int
+	maxAckPending int
+}
+
+func newConsumerSettings(config ewm.WatermillConfig) (*consumerSettings, error) {
+	cs := &consumerSettings{
+		durableName:   config.OptionalValue(OptionDurableName),
+		queueGroup:    config.OptionalValue(OptionQueueGroup),
+		deliverPolicy: nats.DeliverNewPolicy,
+		pullBatchSize: defaultPullBatchSize,
+	}
+
+	var err error
+
+	switch consumerType := strings.ToLower(config.OptionalValue(OptionConsumerType)); consumerType {
+	case "", ConsumerTypePush:
+	case ConsumerTypePull:
+		if cs.durableName == "" {
+			return nil, fmt.Errorf("pull consumers require %s", OptionDurableName)
+		}
+
+		if cs.queueGroup != "" {
+			return nil, fmt.Errorf("%s cannot be used with pull consumers, share %s instead", OptionQueueGroup, OptionDurableName)
+		}
+
+		cs.pull = true
+	default:
+		return nil, fmt.Errorf("invalid value for %s: %s (must be push or pull)", OptionConsumerType, consumerType)
+	}
+
+	if batch, err := config.OptionalInt(OptionPullBatchSize); err != nil {
+		return nil, err
+	} else if batch < 0 {
+		return nil, fmt.Errorf("invalid value for %s: %d", OptionPullBatchSize, batch)
+	} else if batch > 0 {
+		cs.pullBatchSize = batch
+	}
+
+	if value := config.OptionalValue(OptionDeliverPolicy); value != "" {
+		policy, found := deliverPolicies[strings.ToLower(value)]
+
+		if !found {
+			return nil, fmt.Errorf("invalid value for %s: %s (must be all, last, new, sequence or time)", OptionDeliverPolicy, value)
+		}
+
+		cs.deliverPolicy = policy
+	}
+
+	switch cs.deliverPolicy {
+	case nats.DeliverByStartSequencePolicy:
+		value := config.OptionalValue(OptionDeliverStartSequence)
+
+		if cs.startSequence, err = strconv.ParseUint(value, 10, 64); err != nil || cs.startSequence == 0 {
+			return nil, fmt.Errorf("deliver policy sequence requires a positive %s", OptionDeliverStartSequence)
+		}
+	case nats.DeliverByStartTimePolicy:
+		value := config.OptionalValue(OptionDeliverStartTime)
+
+		if cs.startTime, err = time.Parse(time.RFC3339, value); err != nil {
+			return nil, fmt.Errorf("deliver policy time requires an RFC3339 %s", OptionDeliverStartTime)
+		}
+	}
+
+	if cs.ackWait, err = config.OptionalDuration(OptionAckWait); err != nil {
+		return nil, err
+	}
+
+	if cs.maxDeliver, err = config.OptionalInt(OptionMaxDeliver); err != nil {
+		return nil, err
+	}
+
+	if cs.maxAckPending, err = config.OptionalInt(OptionMaxAckPending); err != nil {
+		return nil, err
+	}
+
+	return cs, nil
+}
+
+// subscribeOptions configures consumers created by push subscriptions
+func (cs *consumerSettings) subscribeOptions() []nats.SubOpt {
+	var opts []nats.SubOpt
+
+	switch cs.deliverPolicy {
+	case nats.DeliverAllPolicy:
+		opts = append(opts, nats.DeliverAll())
+	case nats.DeliverLastPolicy:
+		opts = append(opts, nats.DeliverLast())
+	case nats.DeliverNewPolicy:
+		opts = append(opts, nats.DeliverNew())
+	case nats.DeliverByStartSequencePolicy:
+		opts = append(opts, nats.StartSequence(cs.startSequence))
+	case nats.DeliverByStartTimePolicy:
+		opts = append(opts, nats.StartTime(cs.startTime))
+	}
+
+	if cs.ackWait > 0 {
+		opts = append(opts, nats.AckWait(cs.ackWait))
+	}
+
+	if cs.maxDeliver > 0 {
+		opts = append(opts, nats.MaxDeliver(cs.maxDeliver))
+	}
+
+	if cs.maxAckPending > 0 {
+		opts = append(opts, nats.MaxAckPending(cs.maxAckPending))
+	}
+
+	// watermill-jetstream appends to these for each subscription, cap them so it always copies
+	return opts[:len(opts):len(opts)]
+}
+
+// consumerConfig describes the durable consumer created for pull subscriptions
+func (cs *consumerSettings) consumerConfig(subject string) *nats.ConsumerConfig {
+	cc := &nats.ConsumerConfig{
+		Durable:       cs.durableName,
+		DeliverPolicy: cs.deliverPolicy,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       cs.ackWait,
+		MaxDeliver:    cs.maxDeliver,
+		MaxAckPending: cs.maxAckPending,
+		FilterSubject: subject,
+	}
+
+	switch cs.deliverPolicy {
+	case nats.DeliverByStartSequencePolicy:
+		cc.OptStartSeq = cs.startSequence
+	case nats.DeliverByStartTimePolicy:
+		startTime := cs.startTime
+		cc.OptStartTime = &startTime
+	}
+
+	return cc
+}
+
+// streamSettings describes the streams provisioned for topics, nil when the defaults used by
+// watermill-jetstream's own provisioning apply
+type streamSettings struct {
+	subjects  []string
+	retention nats.RetentionPolicy
+	storage   nats.StorageType
+	replicas  int
+	maxAge    time.Duration
+}
+
+// newStreamSettings reads stream provisioning settings, returning whether streams should be
+// provisioned at all
+func newStreamSettings(config ewm.WatermillConfig) (*streamSettings, bool, error) {
+	provision := true
+
+	if value := config.OptionalValue(OptionAutoProvision); value != "" {
+		var err error
+
+		if provision, err = config.OptionalBool(OptionAutoProvision); err != nil {
+			return nil, false, err
+		}
+	}
+
+	configured := false
+
+	for _, key := range []string{OptionStreamSubjects, OptionStreamRetention, OptionStreamStorage, OptionStreamReplicas, OptionStreamMaxAge} {
+		configured = configured || config.OptionalValue(key) != ""
+	}
+
+	if !provision || !configured {
+		return nil, provision, nil
+	}
+
+	ss := &streamSettings{
+		subjects: util.DeleteEmptyAndTrim(strings.FieldsFunc(config.OptionalValue(OptionStreamSubjects), util.SplitComma)),
+	}
+
+	if value := config.OptionalValue(OptionStreamRetention); value != "" {
+		retention, found := retentionPolicies[strings.ToLower(value)]
+
+		if !found {
+			return nil, false, fmt.Errorf("invalid value for %s: %s (must be limits, interest or workqueue)", OptionStreamRetention, value)
+		}
+
+		ss.retention = retention
+	}
+
+	if value := config.OptionalValue(OptionStreamStorage); value != "" {
+		storage, found := storageTypes[strings.ToLower(value)]
+
+		if !found {
+			return nil, false, fmt.Errorf("invalid value for %s: %s (must be file or memory)", OptionStreamStorage, value)
+		}
+
+		ss.storage = storage
+	}
+
+	var err error
+
+	if ss.replicas, err = config.OptionalInt(OptionStreamReplicas); err != nil {
+		return nil, false, err
+	}
+
+	if ss.maxAge, err = config.OptionalDuration(OptionStreamMaxAge); err != nil {
+		return nil, false, err
+	}
+
+	return ss, true, nil
+}
+
+// defaultStreams returns the settings to provision streams with when this package must do it
+// itself, watermill-jetstream only auto provisions for its own publisher and push subscriber
+func defaultStreams(streams *streamSettings, provision bool) *streamSettings {
+	if provision && streams == nil {
+		return &streamSettings{}
+	}
+
+	return streams
+}
+
+// streamConfig describes the stream for a topic, capturing the subjects watermill-jetstream
+// publishes to ("{topic}.*") unless StreamSubjects is set
+func (ss *streamSettings) streamConfig(topic string) *nats.StreamConfig {
+	subjects := []string{topic + ".*"}
+
+	if len(ss.subjects) > 0 {
+		subjects = make([]string, len(ss.subjects))
+
+		for i, subject := range ss.subjects {
+			subjects[i] = strings.ReplaceAll(subject, TopicPlaceholder, topic)
+		}
+	}
+
+	return &nats.StreamConfig{
+		Name:      topic,
+		Subjects:  subjects,
+		Retention: ss.retention,
+		Storage:   ss.storage,
+		Replicas:  ss.replicas,
+		MaxAge:    ss.maxAge,
+	}
+}
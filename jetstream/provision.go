@@ -0,0 +1,97 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jetstream
+
+import (
+	"context"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"sync"
+)
+
+// provisioner creates the stream for each topic on first use with the configured settings
+type provisioner struct {
+	js          nats.JetStreamContext
+	settings    *streamSettings
+	mutex       sync.Mutex
+	provisioned map[string]bool
+}
+
+func newProvisioner(conn *nats.Conn, settings *streamSettings) (*provisioner, error) {
+	js, err := conn.JetStream()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &provisioner{js: js, settings: settings, provisioned: make(map[string]bool)}, nil
+}
+
+// ensureStream creates the stream for topic if it does not exist, existing streams are left as
+// they are
+func (p *provisioner) ensureStream(topic string) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.provisioned[topic] {
+		return nil
+	}
+
+	_, err := p.js.StreamInfo(topic)
+
+	if err == nats.ErrStreamNotFound {
+		_, err = p.js.AddStream(p.settings.streamConfig(topic))
+	}
+
+	if err != nil {
+		return err
+	}
+
+	p.provisioned[topic] = true
+
+	return nil
+}
+
+type provisioningPublisher struct {
+	message.Publisher
+	provisioner *provisioner
+}
+
+func (pp *provisioningPublisher) Publish(topic string, messages ...*message.Message) error {
+	if err := pp.provisioner.ensureStream(topic); err != nil {
+		return err
+	}
+
+	return pp.Publisher.Publish(topic, messages...)
+}
+
+type provisioningSubscriber struct {
+	message.Subscriber
+	provisioner *provisioner
+}
+
+func (ps *provisioningSubscriber) SubscribeInitialize(topic string) error {
+	return ps.provisioner.ensureStream(topic)
+}
+
+func (ps *provisioningSubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	if err := ps.provisioner.ensureStream(topic); err != nil {
+		return nil, err
+	}
+
+	return ps.Subscriber.Subscribe(ctx, topic)
+}
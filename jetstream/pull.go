@@ -0,0 +1,197 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package jetstream
+
+import (
+	"context"
+	"fmt"
+	_nats "github.com/AlexCuse/watermill-jetstream/pkg/jetstream"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"sync"
+	"time"
+)
+
+const pullMaxWait = time.Second
+
+// pullSubscriber fetches messages in batches from a durable pull consumer, which watermill-jetstream
+// does not support.  The consumer is created on the stream named for the topic and is kept when
+// the subscription stops so its position survives restarts.
+type pullSubscriber struct {
+	conn        *nats.Conn
+	js          nats.JetStreamContext
+	settings    *consumerSettings
+	unmarshaler _nats.Unmarshaler
+	logger      watermill.LoggerAdapter
+	closing     chan struct{}
+	closeOnce   sync.Once
+	readers     sync.WaitGroup
+}
+
+func newPullSubscriber(conn *nats.Conn, settings *consumerSettings, unmarshaler _nats.Unmarshaler, logger watermill.LoggerAdapter) (*pullSubscriber, error) {
+	js, err := conn.JetStream()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &pullSubscriber{
+		conn:        conn,
+		js:          js,
+		settings:    settings,
+		unmarshaler: unmarshaler,
+		logger:      logger,
+		closing:     make(chan struct{}),
+	}, nil
+}
+
+func (ps *pullSubscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	subject := topic + ".*"
+	durable := ps.settings.durableName
+
+	if _, err := ps.js.ConsumerInfo(topic, durable); err == nats.ErrConsumerNotFound {
+		if _, err = ps.js.AddConsumer(topic, ps.settings.consumerConfig(subject)); err != nil {
+			return nil, fmt.Errorf("cannot create pull consumer: %s", err.Error())
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("cannot find pull consumer: %s", err.Error())
+	}
+
+	sub, err := ps.js.PullSubscribe(subject, durable, nats.Bind(topic, durable))
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot subscribe: %s", err.Error())
+	}
+
+	output := make(chan *message.Message)
+	logFields := watermill.LogFields{"topic": topic, "durable": durable}
+
+	ps.readers.Add(1)
+
+	go func() {
+		defer ps.readers.Done()
+		defer close(output)
+
+		// bound to an existing consumer, so unsubscribing leaves it in place
+		defer func() {
+			if err := sub.Unsubscribe(); err != nil && err != nats.ErrConnectionClosed {
+				ps.logger.Error("Cannot unsubscribe", err, logFields)
+			}
+		}()
+
+		for ps.running(ctx) {
+			msgs, err := sub.Fetch(ps.settings.pullBatchSize, nats.MaxWait(pullMaxWait))
+
+			if err != nil {
+				if err != nats.ErrTimeout && ps.running(ctx) {
+					ps.logger.Error("Cannot fetch messages", err, logFields)
+					ps.wait(ctx, pullMaxWait)
+				}
+				continue
+			}
+
+			for _, m := range msgs {
+				if !ps.deliver(ctx, m, output, logFields) {
+					return
+				}
+			}
+		}
+	}()
+
+	return output, nil
+}
+
+// deliver sends a fetched message to output and acknowledges it once processed, returning false
+// if the subscription stopped first
+func (ps *pullSubscriber) deliver(ctx context.Context, m *nats.Msg, output chan<- *message.Message, logFields watermill.LogFields) bool {
+	msg, err := ps.unmarshaler.Unmarshal(m)
+
+	if err != nil {
+		// redelivery would fail the same way, so stop the consumer from sending it again
+		ps.logger.Error("Cannot unmarshal message, terminating its delivery", err, logFields)
+
+		if err := m.Term(); err != nil {
+			ps.logger.Error("Cannot send term", err, logFields)
+		}
+
+		return true
+	}
+
+	msgCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	msg.SetContext(msgCtx)
+
+	select {
+	case output <- msg:
+	case <-ctx.Done():
+		_ = m.Nak()
+		return false
+	case <-ps.closing:
+		_ = m.Nak()
+		return false
+	}
+
+	select {
+	case <-msg.Acked():
+		if err := m.Ack(); err != nil {
+			ps.logger.Error("Cannot send ack", err, logFields)
+		}
+	case <-msg.Nacked():
+		if err := m.Nak(); err != nil {
+			ps.logger.Error("Cannot send nak", err, logFields)
+		}
+	case <-ctx.Done():
+		_ = m.Nak()
+		return false
+	case <-ps.closing:
+		_ = m.Nak()
+		return false
+	}
+
+	return true
+}
+
+func (ps *pullSubscriber) running(ctx context.Context) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-ps.closing:
+		return false
+	default:
+		return true
+	}
+}
+
+func (ps *pullSubscriber) wait(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-ps.closing:
+	case <-time.After(d):
+	}
+}
+
+func (ps *pullSubscriber) Close() error {
+	ps.closeOnce.Do(func() {
+		close(ps.closing)
+		ps.readers.Wait()
+		ps.conn.Close()
+	})
+
+	return nil
+}
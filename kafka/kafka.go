@@ -18,60 +18,96 @@ package kafka
 
 import (
 	"context"
-	"github.com/ThreeDotsLabs/watermill"
 	"github.com/ThreeDotsLabs/watermill-kafka/v2/pkg/kafka"
 	"github.com/ThreeDotsLabs/watermill/message"
 	ewm "github.com/alexcuse/edgex-watermill/v2/core"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
 	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
-	"strings"
 )
 
-func kafkaConsumerConfig(config ewm.WatermillConfig) kafka.SubscriberConfig {
-	/*
-		saramaConfig := config.ConfigOverride
+const backendName = "kafka"
 
-		if saramaConfig == nil {
-			saramaConfig = kafka.DefaultSaramaSubscriberConfig()
-		}
-	*/
+func init() {
+	err := ewm.RegisterBackend(backendName, ewm.Backend{
+		Publisher:  Publisher,
+		Subscriber: Subscriber,
+		Trigger:    Trigger,
+		Client:     Client,
+		Sender:     Sender,
+	})
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func kafkaConsumerConfig(config ewm.WatermillConfig) (kafka.SubscriberConfig, error) {
+	saramaConfig := kafka.DefaultSaramaSubscriberConfig()
+
+	if err := configureSecurity(saramaConfig, config); err != nil {
+		return kafka.SubscriberConfig{}, err
+	}
+
+	if err := configureConsumer(saramaConfig, config); err != nil {
+		return kafka.SubscriberConfig{}, err
+	}
 
 	return kafka.SubscriberConfig{
-		Brokers:               []string{config.BrokerUrl},
+		Brokers:               brokers(config),
 		Unmarshaler:           kafka.DefaultMarshaler{},
-		OverwriteSaramaConfig: kafka.DefaultSaramaSubscriberConfig(),
+		OverwriteSaramaConfig: saramaConfig,
 		ConsumerGroup:         config.ConsumerGroup,
-	}
+	}, nil
 }
 
-func kafkaProducerConfig(config ewm.WatermillConfig) kafka.PublisherConfig {
-	return kafka.PublisherConfig{
-		Brokers:               []string{config.BrokerUrl},
-		Marshaler:             kafka.DefaultMarshaler{},
-		OverwriteSaramaConfig: kafka.DefaultSaramaSyncPublisherConfig(),
+func kafkaProducerConfig(config ewm.WatermillConfig) (kafka.PublisherConfig, error) {
+	saramaConfig := kafka.DefaultSaramaSyncPublisherConfig()
+
+	if err := configureSecurity(saramaConfig, config); err != nil {
+		return kafka.PublisherConfig{}, err
+	}
+
+	if err := configureProducer(saramaConfig, config); err != nil {
+		return kafka.PublisherConfig{}, err
+	}
+
+	m, err := marshaler(config)
+
+	if err != nil {
+		return kafka.PublisherConfig{}, err
 	}
+
+	return kafka.PublisherConfig{
+		Brokers:               brokers(config),
+		Marshaler:             m,
+		OverwriteSaramaConfig: saramaConfig,
+	}, nil
 }
 
-func Sender(config ewm.WatermillConfig, proceed bool) (ewm.WatermillSender, error) {
-	pub, err := Publisher(config)
+func Sender(config ewm.WatermillConfig, proceed bool, lc logger.LoggingClient) (ewm.WatermillSender, error) {
+	pub, err := Publisher(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return ewm.NewWatermillSender(
+	return ewm.NewWatermillSenderWithLogger(
 		pub,
 		proceed,
 		&config,
+		lc,
 	)
 }
 
-func Client(ctx context.Context, config ewm.WatermillConfig) (messaging.MessageClient, error) {
+func Client(ctx context.Context, config ewm.WatermillConfig, lc logger.LoggingClient) (messaging.MessageClient, error) {
+	format := ewm.ResolveWireFormat(config.WireFormat, lc)
+
 	var pub message.Publisher
 	var sub message.Subscriber
 
 	if config.PublishTopic != "" {
-		p, err := Publisher(config)
+		p, err := Publisher(config, lc)
 
 		if err != nil {
 			return nil, err
@@ -80,8 +116,9 @@ func Client(ctx context.Context, config ewm.WatermillConfig) (messaging.MessageC
 		pub = p
 	}
 
-	if config.SubscribeTopics != "" {
-		s, err := Subscriber(config)
+	// replies to requests are received through the subscriber as well
+	if config.SubscribeTopics != "" || config.ReplyTopicPrefix != "" {
+		s, err := Subscriber(config, lc)
 
 		if err != nil {
 			return nil, err
@@ -90,70 +127,55 @@ func Client(ctx context.Context, config ewm.WatermillConfig) (messaging.MessageC
 		sub = s
 	}
 
-	var fmt ewm.WireFormat
-
-	switch strings.ToLower(config.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
-	}
-
-	return ewm.NewWatermillClient(
+	return ewm.NewWatermillClientWithLogger(
 		ctx,
 		pub,
 		sub,
-		fmt,
+		format,
 		&config,
+		lc,
 	)
 }
 
-func Publisher(config ewm.WatermillConfig) (message.Publisher, error) {
-	return kafka.NewPublisher(kafkaProducerConfig(config), watermill.NewCaptureLogger())
-}
+func Publisher(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
+	producerConfig, err := kafkaProducerConfig(config)
+
+	if err != nil {
+		return nil, err
+	}
 
-func Subscriber(config ewm.WatermillConfig) (message.Subscriber, error) {
-	return kafka.NewSubscriber(kafkaConsumerConfig(config), watermill.NewCaptureLogger())
+	return kafka.NewPublisher(producerConfig, ewm.NewBackendLogAdapter(lc, backendName))
 }
 
-func Trigger(wc *ewm.WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error) {
-	pub, err := Publisher(wc.WatermillTrigger)
+func Subscriber(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error) {
+	consumerConfig, err := kafkaConsumerConfig(config)
 
 	if err != nil {
 		return nil, err
 	}
 
-	sub, err := Subscriber(wc.WatermillTrigger)
+	return kafka.NewSubscriber(consumerConfig, ewm.NewBackendLogAdapter(lc, backendName))
+}
+
+func Trigger(wc *ewm.WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error) {
+	format := ewm.ResolveWireFormat(wc.WatermillTrigger.WireFormat, cfg.Logger)
+
+	pub, err := Publisher(wc.WatermillTrigger, cfg.Logger)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var fmt ewm.WireFormat
-
-	switch strings.ToLower(wc.WatermillTrigger.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
+	sub, err := Subscriber(wc.WatermillTrigger, cfg.Logger)
+
+	if err != nil {
+		return nil, err
 	}
 
 	return ewm.NewWatermillTrigger(
 		pub,
 		sub,
-		fmt,
+		format,
 		wc,
 		cfg,
 	)
@@ -17,11 +17,17 @@
 package kafka
 
 import (
+	"encoding/pem"
+	"fmt"
 	"github.com/Shopify/sarama"
 	"github.com/ThreeDotsLabs/watermill-kafka/v2/pkg/kafka"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
 	ewm "github.com/alexcuse/edgex-watermill/v2/core"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -37,7 +43,9 @@ func TestKafkaConsumerConfig(t *testing.T) {
 		Optional:        nil,
 	}
 
-	watermillConfig := kafkaConsumerConfig(options)
+	watermillConfig, err := kafkaConsumerConfig(options)
+
+	require.NoError(t, err)
 
 	require.Equal(t, options.BrokerUrl, watermillConfig.Brokers[0], "should set broker URL")
 	require.Equal(t, watermillConfig.ConsumerGroup, options.ConsumerGroup)
@@ -54,3 +62,263 @@ func TestKafkaConsumerConfig(t *testing.T) {
 	require.Equal(t, 3*time.Second, watermillConfig.OverwriteSaramaConfig.Consumer.Group.Heartbeat.Interval)
 	require.Equal(t, 10*time.Second, watermillConfig.OverwriteSaramaConfig.Consumer.Group.Session.Timeout)
 }
+
+type testSecretProvider map[string]map[string]string
+
+func (tsp testSecretProvider) GetSecret(path string, keys ...string) (map[string]string, error) {
+	secrets, found := tsp[path]
+
+	if !found {
+		return nil, fmt.Errorf("no secrets at %s", path)
+	}
+
+	return secrets, nil
+}
+
+func withSecrets(t *testing.T, path string, secrets map[string]string) {
+	ewm.SetSecretProvider(testSecretProvider{path: secrets})
+	t.Cleanup(func() { ewm.SetSecretProvider(nil) })
+}
+
+func testCACert(t *testing.T) string {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+}
+
+func TestKafkaProducerConfig_Brokers(t *testing.T) {
+	producerConfig, err := kafkaProducerConfig(ewm.WatermillConfig{BrokerUrl: "kafka-1:9092, kafka-2:9092,,kafka-3:9092"})
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"kafka-1:9092", "kafka-2:9092", "kafka-3:9092"}, producerConfig.Brokers)
+	require.False(t, producerConfig.OverwriteSaramaConfig.Net.SASL.Enable)
+	require.False(t, producerConfig.OverwriteSaramaConfig.Net.TLS.Enable)
+}
+
+func TestKafkaConsumerConfig_SASL(t *testing.T) {
+	tests := []struct {
+		mechanism string
+		expected  sarama.SASLMechanism
+		scram     bool
+	}{
+		{"plain", sarama.SASLTypePlaintext, false},
+		{"SCRAM-SHA-256", sarama.SASLTypeSCRAMSHA256, true},
+		{"scram-sha-512", sarama.SASLTypeSCRAMSHA512, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mechanism, func(t *testing.T) {
+			path := uuid.NewString()
+
+			withSecrets(t, path, map[string]string{SecretUsername: "user", SecretPassword: "pass"})
+
+			consumerConfig, err := kafkaConsumerConfig(ewm.WatermillConfig{
+				BrokerUrl: "kafka:9092",
+				Optional:  map[string]string{OptionSecretPath: path, "saslmechanism": tt.mechanism},
+			})
+
+			require.NoError(t, err)
+
+			sasl := consumerConfig.OverwriteSaramaConfig.Net.SASL
+
+			require.True(t, sasl.Enable)
+			require.Equal(t, tt.expected, sasl.Mechanism)
+			require.Equal(t, "user", sasl.User)
+			require.Equal(t, "pass", sasl.Password)
+
+			if tt.scram {
+				require.NotNil(t, sasl.SCRAMClientGeneratorFunc)
+				require.NoError(t, sasl.SCRAMClientGeneratorFunc().Begin("user", "pass", ""))
+			}
+
+			require.NoError(t, consumerConfig.OverwriteSaramaConfig.Validate())
+		})
+	}
+}
+
+func TestKafkaConsumerConfig_SASLErrors(t *testing.T) {
+	path := uuid.NewString()
+
+	withSecrets(t, path, map[string]string{SecretUsername: "user"})
+
+	_, err := kafkaConsumerConfig(ewm.WatermillConfig{Optional: map[string]string{OptionSecretPath: path, OptionSASLMechanism: "PLAIN"}})
+	require.Error(t, err, "password should be required")
+
+	_, err = kafkaConsumerConfig(ewm.WatermillConfig{Optional: map[string]string{OptionSecretPath: uuid.NewString(), OptionSASLMechanism: "PLAIN"}})
+	require.Error(t, err, "secret store errors should be returned")
+
+	_, err = kafkaConsumerConfig(ewm.WatermillConfig{Optional: map[string]string{OptionSecretPath: path, OptionSASLMechanism: "GSSAPI"}})
+	require.Error(t, err, "unsupported mechanisms should be rejected")
+}
+
+func TestKafkaProducerConfig_TLS(t *testing.T) {
+	path := uuid.NewString()
+
+	withSecrets(t, path, map[string]string{SecretCACert: testCACert(t)})
+
+	producerConfig, err := kafkaProducerConfig(ewm.WatermillConfig{
+		Optional: map[string]string{OptionSecretPath: path, OptionTLSSkipVerify: "false"},
+	})
+
+	require.NoError(t, err)
+
+	tlsConfig := producerConfig.OverwriteSaramaConfig.Net.TLS
+
+	require.True(t, tlsConfig.Enable, "CA cert should enable TLS")
+	require.NotNil(t, tlsConfig.Config.RootCAs)
+	require.False(t, tlsConfig.Config.InsecureSkipVerify)
+	require.Empty(t, tlsConfig.Config.Certificates)
+}
+
+func TestKafkaProducerConfig_TLSErrors(t *testing.T) {
+	path := uuid.NewString()
+
+	withSecrets(t, path, map[string]string{SecretCACert: "not a cert", SecretClientCert: "not a cert"})
+
+	_, err := kafkaProducerConfig(ewm.WatermillConfig{Optional: map[string]string{OptionTLS: "maybe"}})
+	require.Error(t, err)
+
+	_, err = kafkaProducerConfig(ewm.WatermillConfig{Optional: map[string]string{OptionSecretPath: path}})
+	require.Error(t, err)
+}
+
+func TestKafkaProducerConfig_NoSecretProvider(t *testing.T) {
+	_, err := kafkaProducerConfig(ewm.WatermillConfig{Optional: map[string]string{OptionSecretPath: uuid.NewString()}})
+
+	require.Error(t, err)
+}
+
+func TestKafkaConsumerConfig_Tuning(t *testing.T) {
+	consumerConfig, err := kafkaConsumerConfig(ewm.WatermillConfig{
+		Optional: map[string]string{
+			OptionVersion:           "2.6.0",
+			OptionInitialOffset:     "Oldest",
+			OptionSessionTimeout:    "30s",
+			OptionFetchMinBytes:     "16",
+			OptionFetchDefaultBytes: "2048",
+			OptionFetchMaxBytes:     "1048576",
+		},
+	})
+
+	require.NoError(t, err)
+
+	saramaConfig := consumerConfig.OverwriteSaramaConfig
+
+	require.Equal(t, sarama.V2_6_0_0, saramaConfig.Version)
+	require.Equal(t, sarama.OffsetOldest, saramaConfig.Consumer.Offsets.Initial)
+	require.Equal(t, 30*time.Second, saramaConfig.Consumer.Group.Session.Timeout)
+	require.Equal(t, int32(16), saramaConfig.Consumer.Fetch.Min)
+	require.Equal(t, int32(2048), saramaConfig.Consumer.Fetch.Default)
+	require.Equal(t, int32(1048576), saramaConfig.Consumer.Fetch.Max)
+}
+
+func TestKafkaProducerConfig_Tuning(t *testing.T) {
+	producerConfig, err := kafkaProducerConfig(ewm.WatermillConfig{
+		Optional: map[string]string{
+			OptionVersion:         "2.6.0",
+			OptionCompression:     "zstd",
+			OptionIdempotent:      "true",
+			OptionMaxMessageBytes: "2000000",
+		},
+	})
+
+	require.NoError(t, err)
+
+	saramaConfig := producerConfig.OverwriteSaramaConfig
+
+	require.Equal(t, sarama.CompressionZSTD, saramaConfig.Producer.Compression)
+	require.True(t, saramaConfig.Producer.Idempotent)
+	require.Equal(t, sarama.WaitForAll, saramaConfig.Producer.RequiredAcks, "idempotence should default to acks from all replicas")
+	require.Equal(t, 1, saramaConfig.Net.MaxOpenRequests)
+	require.Equal(t, 2000000, saramaConfig.Producer.MaxMessageBytes)
+
+	producerConfig, err = kafkaProducerConfig(ewm.WatermillConfig{Optional: map[string]string{OptionRequiredAcks: "local"}})
+
+	require.NoError(t, err)
+	require.Equal(t, sarama.WaitForLocal, producerConfig.OverwriteSaramaConfig.Producer.RequiredAcks)
+}
+
+func TestKafkaConfig_InvalidTuning(t *testing.T) {
+	consumerOptions := []map[string]string{
+		{OptionVersion: "latest"},
+		{OptionInitialOffset: "middle"},
+		{OptionSessionTimeout: "30"},
+		{OptionFetchMaxBytes: "lots"},
+		{OptionFetchMinBytes: "0"},
+	}
+
+	for _, optional := range consumerOptions {
+		_, err := kafkaConsumerConfig(ewm.WatermillConfig{Optional: optional})
+		require.Error(t, err, optional)
+	}
+
+	producerOptions := []map[string]string{
+		{OptionCompression: "brotli"},
+		{OptionRequiredAcks: "some"},
+		{OptionIdempotent: "yes please"},
+		{OptionMaxMessageBytes: "-1"},
+		{OptionIdempotent: "true", OptionRequiredAcks: "local"},
+		{OptionIdempotent: "true", OptionVersion: "0.10.2.0"},
+		{OptionTransactional: "true"},
+		{OptionTransactional: "maybe"},
+	}
+
+	for _, optional := range producerOptions {
+		_, err := kafkaProducerConfig(ewm.WatermillConfig{Optional: optional})
+		require.Error(t, err, optional)
+	}
+}
+
+func TestKafkaProducerConfig_PartitionKey(t *testing.T) {
+	producerConfig, err := kafkaProducerConfig(ewm.WatermillConfig{})
+
+	require.NoError(t, err)
+	require.Equal(t, kafka.DefaultMarshaler{}, producerConfig.Marshaler, "should not partition unless configured")
+
+	_, err = kafkaProducerConfig(ewm.WatermillConfig{PartitionKey: "devicename"})
+
+	require.Error(t, err)
+}
+
+func TestMarshaler_PartitionKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		spec     string
+		metadata map[string]string
+		expected string
+	}{
+		{"correlation id", "CorrelationID", map[string]string{middleware.CorrelationIDMetadataKey: "correlation"}, "correlation"},
+		{"metadata", "metadata:Device", map[string]string{"Device": "thermostat"}, "thermostat"},
+		{"template", "{devicename}", map[string]string{ewm.PartitionKeyMetadataKey: "thermostat"}, "thermostat"},
+		{"missing", "metadata:device", nil, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := marshaler(ewm.WatermillConfig{PartitionKey: tt.spec})
+
+			require.NoError(t, err)
+
+			msg := message.NewMessage(uuid.NewString(), []byte("{}"))
+
+			for k, v := range tt.metadata {
+				msg.Metadata.Set(k, v)
+			}
+
+			produced, err := m.Marshal("topic", msg)
+
+			require.NoError(t, err)
+
+			key, err := produced.Key.Encode()
+
+			require.NoError(t, err)
+
+			if tt.expected == "" {
+				require.Equal(t, msg.UUID, string(key), "should fall back to message UUID")
+			} else {
+				require.Equal(t, tt.expected, string(key))
+			}
+		})
+	}
+}
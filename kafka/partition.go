@@ -0,0 +1,67 @@
+//
+// Copyright (c) 2020 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kafka
+
+import (
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill-kafka/v2/pkg/kafka"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/ThreeDotsLabs/watermill/message/router/middleware"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"strings"
+)
+
+// marshaler partitions messages by the configured PartitionKey, messages without a key are
+// spread across partitions by their UUID
+func marshaler(config ewm.WatermillConfig) (kafka.MarshalerUnmarshaler, error) {
+	spec := strings.TrimSpace(config.PartitionKey)
+
+	if spec == "" {
+		return kafka.DefaultMarshaler{}, nil
+	}
+
+	var key func(msg *message.Message) string
+
+	lower := strings.ToLower(spec)
+
+	switch {
+	case lower == ewm.PartitionKeyCorrelationID:
+		key = func(msg *message.Message) string {
+			return msg.Metadata.Get(middleware.CorrelationIDMetadataKey)
+		}
+	case strings.HasPrefix(lower, ewm.PartitionKeyMetadataPrefix) && len(spec) > len(ewm.PartitionKeyMetadataPrefix):
+		name := spec[len(ewm.PartitionKeyMetadataPrefix):]
+
+		key = func(msg *message.Message) string {
+			return msg.Metadata.Get(name)
+		}
+	case ewm.IsPartitionKeyTemplate(spec):
+		key = func(msg *message.Message) string {
+			return msg.Metadata.Get(ewm.PartitionKeyMetadataKey)
+		}
+	default:
+		return nil, fmt.Errorf("invalid partition key specified: %s", spec)
+	}
+
+	return kafka.NewWithPartitioningMarshaler(func(topic string, msg *message.Message) (string, error) {
+		if k := key(msg); k != "" {
+			return k, nil
+		}
+
+		return msg.UUID, nil
+	}), nil
+}
@@ -0,0 +1,169 @@
+//
+// Copyright (c) 2020 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"github.com/Shopify/sarama"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/util"
+	"github.com/xdg-go/scram"
+	"strings"
+)
+
+// Optional settings read from WatermillConfig.Optional
+const (
+	OptionSecretPath    = "SecretPath"
+	OptionSASLMechanism = "SASLMechanism"
+	OptionTLS           = "TLS"
+	OptionTLSSkipVerify = "TLSSkipVerify"
+)
+
+// Keys read from the secret store at SecretPath
+const (
+	SecretUsername   = ewm.SecretUsername
+	SecretPassword   = ewm.SecretPassword
+	SecretCACert     = ewm.SecretCACert
+	SecretClientCert = ewm.SecretClientCert
+	SecretClientKey  = ewm.SecretClientKey
+)
+
+const (
+	SASLMechanismPlain       = "PLAIN"
+	SASLMechanismSCRAMSHA256 = "SCRAM-SHA-256"
+	SASLMechanismSCRAMSHA512 = "SCRAM-SHA-512"
+)
+
+// brokers splits the comma separated BrokerUrl
+func brokers(config ewm.WatermillConfig) []string {
+	return util.DeleteEmptyAndTrim(strings.FieldsFunc(config.BrokerUrl, util.SplitComma))
+}
+
+// configureSecurity applies the TLS and SASL settings from config, reading credentials and
+// certificates from the secret store
+func configureSecurity(sc *sarama.Config, config ewm.WatermillConfig) error {
+	secrets := make(map[string]string)
+
+	if path := config.OptionalValue(OptionSecretPath); path != "" {
+		var err error
+
+		if secrets, err = ewm.GetSecret(path); err != nil {
+			return err
+		}
+	}
+
+	if err := configureSASL(sc, config, secrets); err != nil {
+		return err
+	}
+
+	return configureTLS(sc, config, secrets)
+}
+
+func configureSASL(sc *sarama.Config, config ewm.WatermillConfig, secrets map[string]string) error {
+	mechanism := strings.ToUpper(strings.TrimSpace(config.OptionalValue(OptionSASLMechanism)))
+
+	if mechanism == "" {
+		return nil
+	}
+
+	switch mechanism {
+	case SASLMechanismPlain:
+		sc.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLMechanismSCRAMSHA256:
+		sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		sc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: sha256.New}
+		}
+	case SASLMechanismSCRAMSHA512:
+		sc.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		sc.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: sha512.New}
+		}
+	default:
+		return fmt.Errorf("unsupported SASL mechanism: %s", mechanism)
+	}
+
+	if secrets[SecretUsername] == "" || secrets[SecretPassword] == "" {
+		return fmt.Errorf("SASL requires %s and %s secrets at %s", SecretUsername, SecretPassword, OptionSecretPath)
+	}
+
+	sc.Net.SASL.Enable = true
+	sc.Net.SASL.Handshake = true
+	sc.Net.SASL.User = secrets[SecretUsername]
+	sc.Net.SASL.Password = secrets[SecretPassword]
+
+	return nil
+}
+
+func configureTLS(sc *sarama.Config, config ewm.WatermillConfig, secrets map[string]string) error {
+	enabled, err := config.OptionalBool(OptionTLS)
+
+	if err != nil {
+		return err
+	}
+
+	skipVerify, err := config.OptionalBool(OptionTLSSkipVerify)
+
+	if err != nil {
+		return err
+	}
+
+	caCert, clientCert, clientKey := secrets[SecretCACert], secrets[SecretClientCert], secrets[SecretClientKey]
+
+	if !enabled && !skipVerify && caCert == "" && clientCert == "" && clientKey == "" {
+		return nil
+	}
+
+	tc, err := ewm.NewTLSConfig(caCert, clientCert, clientKey, skipVerify)
+
+	if err != nil {
+		return err
+	}
+
+	sc.Net.TLS.Enable = true
+	sc.Net.TLS.Config = tc
+
+	return nil
+}
+
+// scramClient adapts xdg-go/scram to sarama's SCRAMClient
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (sc *scramClient) Begin(userName, password, authzID string) error {
+	client, err := sc.hash.NewClient(userName, password, authzID)
+
+	if err != nil {
+		return err
+	}
+
+	sc.conversation = client.NewConversation()
+
+	return nil
+}
+
+func (sc *scramClient) Step(challenge string) (string, error) {
+	return sc.conversation.Step(challenge)
+}
+
+func (sc *scramClient) Done() bool {
+	return sc.conversation.Done()
+}
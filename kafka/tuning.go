@@ -0,0 +1,207 @@
+//
+// Copyright (c) 2020 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package kafka
+
+import (
+	"fmt"
+	"github.com/Shopify/sarama"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Optional settings mapped onto the sarama configuration
+const (
+	OptionVersion           = "Version"
+	OptionInitialOffset     = "InitialOffset"
+	OptionSessionTimeout    = "SessionTimeout"
+	OptionFetchMinBytes     = "FetchMinBytes"
+	OptionFetchDefaultBytes = "FetchDefaultBytes"
+	OptionFetchMaxBytes     = "FetchMaxBytes"
+	OptionCompression       = "Compression"
+	OptionRequiredAcks      = "RequiredAcks"
+	OptionIdempotent        = "Idempotent"
+	OptionMaxMessageBytes   = "MaxMessageBytes"
+	OptionTransactional     = "Transactional"
+)
+
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	"none":   sarama.CompressionNone,
+	"gzip":   sarama.CompressionGZIP,
+	"snappy": sarama.CompressionSnappy,
+	"lz4":    sarama.CompressionLZ4,
+	"zstd":   sarama.CompressionZSTD,
+}
+
+var requiredAcks = map[string]sarama.RequiredAcks{
+	"none":  sarama.NoResponse,
+	"0":     sarama.NoResponse,
+	"local": sarama.WaitForLocal,
+	"1":     sarama.WaitForLocal,
+	"all":   sarama.WaitForAll,
+	"-1":    sarama.WaitForAll,
+}
+
+func int32Option(config ewm.WatermillConfig, key string, target *int32) error {
+	value := config.OptionalValue(key)
+
+	if value == "" {
+		return nil
+	}
+
+	i, err := strconv.ParseInt(value, 10, 32)
+
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %s", key, value)
+	}
+
+	*target = int32(i)
+
+	return nil
+}
+
+func configureVersion(sc *sarama.Config, config ewm.WatermillConfig) error {
+	value := config.OptionalValue(OptionVersion)
+
+	if value == "" {
+		return nil
+	}
+
+	version, err := sarama.ParseKafkaVersion(value)
+
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %s", OptionVersion, err.Error())
+	}
+
+	sc.Version = version
+
+	return nil
+}
+
+// configureConsumer applies consumer tuning from config
+func configureConsumer(sc *sarama.Config, config ewm.WatermillConfig) error {
+	if err := configureVersion(sc, config); err != nil {
+		return err
+	}
+
+	switch offset := strings.ToLower(config.OptionalValue(OptionInitialOffset)); offset {
+	case "":
+	case "newest":
+		sc.Consumer.Offsets.Initial = sarama.OffsetNewest
+	case "oldest":
+		sc.Consumer.Offsets.Initial = sarama.OffsetOldest
+	default:
+		return fmt.Errorf("invalid value for %s: %s (must be oldest or newest)", OptionInitialOffset, offset)
+	}
+
+	if timeout := config.OptionalValue(OptionSessionTimeout); timeout != "" {
+		var err error
+
+		if sc.Consumer.Group.Session.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return fmt.Errorf("invalid value for %s: %s", OptionSessionTimeout, err.Error())
+		}
+	}
+
+	if err := int32Option(config, OptionFetchMinBytes, &sc.Consumer.Fetch.Min); err != nil {
+		return err
+	}
+
+	if err := int32Option(config, OptionFetchDefaultBytes, &sc.Consumer.Fetch.Default); err != nil {
+		return err
+	}
+
+	if err := int32Option(config, OptionFetchMaxBytes, &sc.Consumer.Fetch.Max); err != nil {
+		return err
+	}
+
+	return validate(sc)
+}
+
+// configureProducer applies producer tuning from config
+func configureProducer(sc *sarama.Config, config ewm.WatermillConfig) error {
+	if err := configureVersion(sc, config); err != nil {
+		return err
+	}
+
+	if value := config.OptionalValue(OptionCompression); value != "" {
+		codec, found := compressionCodecs[strings.ToLower(value)]
+
+		if !found {
+			return fmt.Errorf("invalid value for %s: %s", OptionCompression, value)
+		}
+
+		sc.Producer.Compression = codec
+	}
+
+	acksSet := false
+
+	if value := config.OptionalValue(OptionRequiredAcks); value != "" {
+		acks, found := requiredAcks[strings.ToLower(value)]
+
+		if !found {
+			return fmt.Errorf("invalid value for %s: %s (must be none, local or all)", OptionRequiredAcks, value)
+		}
+
+		sc.Producer.RequiredAcks = acks
+		acksSet = true
+	}
+
+	transactional, err := config.OptionalBool(OptionTransactional)
+
+	if err != nil {
+		return err
+	}
+
+	// sarama v1.27.2, used by watermill-kafka v2.2.0, has no transactional producer
+	if transactional {
+		return fmt.Errorf("%s is not supported by the kafka backend, use %s with trigger Dedup instead", OptionTransactional, OptionIdempotent)
+	}
+
+	idempotent, err := config.OptionalBool(OptionIdempotent)
+
+	if err != nil {
+		return err
+	}
+
+	if idempotent {
+		sc.Producer.Idempotent = true
+
+		// sarama only allows idempotence with a single in flight request and acks from all replicas
+		sc.Net.MaxOpenRequests = 1
+
+		if !acksSet {
+			sc.Producer.RequiredAcks = sarama.WaitForAll
+		}
+	}
+
+	if value := config.OptionalValue(OptionMaxMessageBytes); value != "" {
+		if sc.Producer.MaxMessageBytes, err = strconv.Atoi(value); err != nil {
+			return fmt.Errorf("invalid value for %s: %s", OptionMaxMessageBytes, value)
+		}
+	}
+
+	return validate(sc)
+}
+
+func validate(sc *sarama.Config) error {
+	if err := sc.Validate(); err != nil {
+		return fmt.Errorf("invalid kafka configuration: %s", err.Error())
+	}
+
+	return nil
+}
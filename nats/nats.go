@@ -19,118 +19,219 @@ package nats
 import (
 	"context"
 	"fmt"
-	"github.com/ThreeDotsLabs/watermill"
 	_nats "github.com/ThreeDotsLabs/watermill-nats/pkg/nats"
 	"github.com/ThreeDotsLabs/watermill/message"
 	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/alexcuse/edgex-watermill/v2/natscommon"
 	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
 	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
+	"github.com/nats-io/nats.go"
 	"github.com/nats-io/stan.go"
-	"os"
-	"strings"
 )
 
-func Sender(config ewm.WatermillConfig, proceed bool) (ewm.WatermillSender, error) {
-	pub, err := Publisher(config)
+const backendName = "nats"
+
+func init() {
+	err := ewm.RegisterBackend(backendName, ewm.Backend{
+		Publisher:  Publisher,
+		Subscriber: Subscriber,
+		Trigger:    Trigger,
+		Client:     Client,
+		Sender:     Sender,
+	})
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func Sender(config ewm.WatermillConfig, proceed bool, lc logger.LoggingClient) (ewm.WatermillSender, error) {
+	pub, err := Publisher(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return ewm.NewWatermillSender(
+	return ewm.NewWatermillSenderWithLogger(
 		pub,
 		proceed,
 		&config,
+		lc,
 	)
 }
 
-func Client(ctx context.Context, config ewm.WatermillConfig) (messaging.MessageClient, error) {
-	pub, err := Publisher(config)
+func Client(ctx context.Context, config ewm.WatermillConfig, lc logger.LoggingClient) (messaging.MessageClient, error) {
+	format := ewm.ResolveWireFormat(config.WireFormat, lc)
+
+	pub, err := Publisher(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	sub, err := Subscriber(config)
+	sub, err := Subscriber(config, lc)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var fmt ewm.WireFormat
-
-	switch strings.ToLower(config.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
-	}
-
-	return ewm.NewWatermillClient(
+	return ewm.NewWatermillClientWithLogger(
 		ctx,
 		pub,
 		sub,
-		fmt,
+		format,
 		&config,
+		lc,
 	)
 }
 
-func Publisher(config ewm.WatermillConfig) (message.Publisher, error) {
-	return _nats.NewStreamingPublisher(_nats.StreamingPublisherConfig{
+func Publisher(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
+	if err := checkMarshaler(config); err != nil {
+		return nil, err
+	}
+
+	stanOptions, conn, err := connect(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := _nats.NewStreamingPublisher(_nats.StreamingPublisherConfig{
 		ClusterID:   config.Optional["ClusterId"],
 		ClientID:    fmt.Sprintf("pub-%s", config.ClientId),
-		StanOptions: []stan.Option{stan.NatsURL(config.BrokerUrl)},
+		StanOptions: stanOptions,
 		Marshaler:   _nats.GobMarshaler{},
-	}, watermill.NewStdLoggerWithOut(os.Stdout, true, false))
+	}, ewm.NewBackendLogAdapter(lc, backendName))
+
+	if err != nil {
+		closeConn(conn)
+		return nil, err
+	}
+
+	if conn == nil {
+		return pub, nil
+	}
+
+	return &connPublisher{StreamingPublisher: pub, conn: conn}, nil
 }
 
-func Subscriber(config ewm.WatermillConfig) (message.Subscriber, error) {
-	return _nats.NewStreamingSubscriber(_nats.StreamingSubscriberConfig{
+func Subscriber(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error) {
+	if err := checkMarshaler(config); err != nil {
+		return nil, err
+	}
+
+	stanOptions, conn, err := connect(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := _nats.NewStreamingSubscriber(_nats.StreamingSubscriberConfig{
 		ClusterID:   config.Optional["ClusterId"],
 		ClientID:    fmt.Sprintf("sub-%s", config.ClientId),
-		StanOptions: []stan.Option{stan.NatsURL(config.BrokerUrl)},
+		StanOptions: stanOptions,
 		Unmarshaler: _nats.GobMarshaler{},
-	}, watermill.NewStdLoggerWithOut(os.Stdout, true, false))
+	}, ewm.NewBackendLogAdapter(lc, backendName))
+
+	if err != nil {
+		closeConn(conn)
+		return nil, err
+	}
+
+	if conn == nil {
+		return sub, nil
+	}
+
+	return &connSubscriber{StreamingSubscriber: sub, conn: conn}, nil
 }
 
-func Trigger(wc *ewm.WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error) {
-	pub, err := Publisher(wc.WatermillTrigger)
+// checkMarshaler rejects marshalers other than gob, stan messages have no headers to carry metadata
+func checkMarshaler(config ewm.WatermillConfig) error {
+	marshaler, err := natscommon.MarshalerType(config, natscommon.MarshalerGob)
 
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if marshaler != natscommon.MarshalerGob {
+		return fmt.Errorf("NATS Streaming does not support the %s marshaler, use the natscore or jetstream backend", marshaler)
 	}
 
-	sub, err := Subscriber(wc.WatermillTrigger)
+	return nil
+}
+
+// connect opens the NATS connection used by the streaming client when authentication or TLS is
+// configured, otherwise stan connects to BrokerUrl itself
+func connect(config ewm.WatermillConfig) ([]stan.Option, *nats.Conn, error) {
+	authOptions, err := natscommon.AuthOptions(config)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(authOptions) == 0 {
+		return []stan.Option{stan.NatsURL(config.BrokerUrl)}, nil, nil
+	}
+
+	conn, err := natscommon.Connect(config)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return []stan.Option{stan.NatsConn(conn)}, conn, nil
+}
+
+func closeConn(conn *nats.Conn) {
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// connPublisher closes the NATS connection it was given, which stan leaves open
+type connPublisher struct {
+	*_nats.StreamingPublisher
+	conn *nats.Conn
+}
+
+func (cp *connPublisher) Close() error {
+	defer cp.conn.Close()
+
+	return cp.StreamingPublisher.Close()
+}
+
+// connSubscriber closes the NATS connection it was given, which stan leaves open
+type connSubscriber struct {
+	*_nats.StreamingSubscriber
+	conn *nats.Conn
+}
+
+func (cs *connSubscriber) Close() error {
+	defer cs.conn.Close()
+
+	return cs.StreamingSubscriber.Close()
+}
+
+func Trigger(wc *ewm.WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error) {
+	format := ewm.ResolveWireFormat(wc.WatermillTrigger.WireFormat, cfg.Logger)
+
+	pub, err := Publisher(wc.WatermillTrigger, cfg.Logger)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var fmt ewm.WireFormat
+	sub, err := Subscriber(wc.WatermillTrigger, cfg.Logger)
 
-	switch strings.ToLower(wc.WatermillTrigger.WireFormat) {
-	case "raw":
-		fmt = &ewm.RawWireFormat{}
-	case "rawinput":
-		fmt = &ewm.RawInputWireFormat{}
-	case "rawoutput":
-		fmt = &ewm.RawOutputWireFormat{}
-	case "edgex":
-		fmt = &ewm.EdgeXWireFormat{}
-	default:
-		fmt = &ewm.EdgeXWireFormat{}
+	if err != nil {
+		return nil, err
 	}
 
 	return ewm.NewWatermillTrigger(
 		pub,
 		sub,
-		fmt,
+		format,
 		wc,
 		cfg,
 	)
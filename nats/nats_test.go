@@ -0,0 +1,34 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package nats
+
+import (
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/alexcuse/edgex-watermill/v2/natscommon"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestHeadersMarshaler(t *testing.T) {
+	config := ewm.WatermillConfig{Optional: map[string]string{natscommon.OptionMarshaler: natscommon.MarshalerHeaders}}
+
+	_, err := Publisher(config, nil)
+	require.Error(t, err)
+
+	_, err = Subscriber(config, nil)
+	require.Error(t, err)
+}
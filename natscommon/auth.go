@@ -0,0 +1,176 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package natscommon
+
+import (
+	"fmt"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+)
+
+// Optional settings read from WatermillConfig.Optional
+const (
+	OptionSecretPath      = "SecretPath"
+	OptionCredentialsFile = "CredentialsFile"
+	OptionNKeySeedFile    = "NKeySeedFile"
+	OptionTLS             = "TLS"
+	OptionTLSSkipVerify   = "TLSSkipVerify"
+)
+
+// Keys read from the secret store at SecretPath
+const (
+	SecretUsername    = ewm.SecretUsername
+	SecretPassword    = ewm.SecretPassword
+	SecretToken       = "token"
+	SecretCredentials = "creds"
+	SecretNKeySeed    = "nkeyseed"
+	SecretCACert      = ewm.SecretCACert
+	SecretClientCert  = ewm.SecretClientCert
+	SecretClientKey   = ewm.SecretClientKey
+)
+
+// AuthOptions builds the NATS connection options for the authentication and TLS settings in
+// config, reading credentials from the secret store where they are kept.  Only one of user
+// credentials (JWT), NKey, username and password or token may be configured.
+func AuthOptions(config ewm.WatermillConfig) ([]nats.Option, error) {
+	secrets := make(map[string]string)
+
+	if path := config.OptionalValue(OptionSecretPath); path != "" {
+		var err error
+
+		if secrets, err = ewm.GetSecret(path); err != nil {
+			return nil, err
+		}
+	}
+
+	opts, err := authenticationOptions(config, secrets)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tlsOpt, err := tlsOption(config, secrets)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsOpt != nil {
+		opts = append(opts, tlsOpt)
+	}
+
+	return opts, nil
+}
+
+func authenticationOptions(config ewm.WatermillConfig, secrets map[string]string) ([]nats.Option, error) {
+	var opts []nats.Option
+
+	if file := config.OptionalValue(OptionCredentialsFile); file != "" {
+		opts = append(opts, nats.UserCredentials(file))
+	}
+
+	if creds := secrets[SecretCredentials]; creds != "" {
+		jwt, err := nkeys.ParseDecoratedJWT([]byte(creds))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s secret: %s", SecretCredentials, err.Error())
+		}
+
+		kp, err := nkeys.ParseDecoratedNKey([]byte(creds))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s secret: %s", SecretCredentials, err.Error())
+		}
+
+		opts = append(opts, nats.UserJWT(func() (string, error) { return jwt, nil }, kp.Sign))
+	}
+
+	if file := config.OptionalValue(OptionNKeySeedFile); file != "" {
+		opt, err := nats.NkeyOptionFromSeed(file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, opt)
+	}
+
+	if seed := secrets[SecretNKeySeed]; seed != "" {
+		kp, err := nkeys.FromSeed([]byte(seed))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s secret: %s", SecretNKeySeed, err.Error())
+		}
+
+		publicKey, err := kp.PublicKey()
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s secret: %s", SecretNKeySeed, err.Error())
+		}
+
+		opts = append(opts, nats.Nkey(publicKey, kp.Sign))
+	}
+
+	username, password := secrets[SecretUsername], secrets[SecretPassword]
+
+	if username != "" || password != "" {
+		if username == "" || password == "" {
+			return nil, fmt.Errorf("both %s and %s secrets are required", SecretUsername, SecretPassword)
+		}
+
+		opts = append(opts, nats.UserInfo(username, password))
+	}
+
+	if token := secrets[SecretToken]; token != "" {
+		opts = append(opts, nats.Token(token))
+	}
+
+	if len(opts) > 1 {
+		return nil, fmt.Errorf("only one of user credentials, nkey, username and password or token can be used to authenticate")
+	}
+
+	return opts, nil
+}
+
+func tlsOption(config ewm.WatermillConfig, secrets map[string]string) (nats.Option, error) {
+	enabled, err := config.OptionalBool(OptionTLS)
+
+	if err != nil {
+		return nil, err
+	}
+
+	skipVerify, err := config.OptionalBool(OptionTLSSkipVerify)
+
+	if err != nil {
+		return nil, err
+	}
+
+	caCert, clientCert, clientKey := secrets[SecretCACert], secrets[SecretClientCert], secrets[SecretClientKey]
+
+	if !enabled && !skipVerify && caCert == "" && clientCert == "" && clientKey == "" {
+		return nil, nil
+	}
+
+	tc, err := ewm.NewTLSConfig(caCert, clientCert, clientKey, skipVerify)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return nats.Secure(tc), nil
+}
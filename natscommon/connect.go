@@ -0,0 +1,99 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package natscommon
+
+import (
+	"fmt"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/nats-io/nats.go"
+	"time"
+)
+
+// Optional connection settings read from WatermillConfig.Optional
+const (
+	OptionConnectTimeout = "ConnectTimeout"
+	OptionReconnectWait  = "ReconnectWait"
+	OptionMaxReconnects  = "MaxReconnects"
+)
+
+const (
+	defaultConnectTimeout = 5 * time.Second
+	defaultReconnectWait  = time.Second
+)
+
+// ConnectOptions builds the options used by the NATS backends to connect to the server, including
+// the authentication and TLS settings read by AuthOptions
+func ConnectOptions(config ewm.WatermillConfig) ([]nats.Option, error) {
+	connectTimeout, err := config.OptionalDuration(OptionConnectTimeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if connectTimeout == 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+
+	reconnectWait, err := config.OptionalDuration(OptionReconnectWait)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if reconnectWait == 0 {
+		reconnectWait = defaultReconnectWait
+	}
+
+	opts := []nats.Option{nats.Timeout(connectTimeout), nats.ReconnectWait(reconnectWait)}
+
+	if config.OptionalValue(OptionMaxReconnects) != "" {
+		maxReconnects, err := config.OptionalInt(OptionMaxReconnects)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, nats.MaxReconnects(maxReconnects))
+	}
+
+	authOptions, err := AuthOptions(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return append(opts, authOptions...), nil
+}
+
+// Connect opens a NATS connection to BrokerUrl using ConnectOptions, followed by any extra options
+func Connect(config ewm.WatermillConfig, extra ...nats.Option) (*nats.Conn, error) {
+	opts, err := ConnectOptions(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append(opts, extra...)
+
+	conn, err := nats.Connect(config.BrokerUrl, opts...)
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to NATS: %s", err.Error())
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,93 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package natscommon
+
+import (
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/nats-io/nats.go"
+	"strings"
+)
+
+// OptionMarshaler selects how messages are encoded on the wire
+const OptionMarshaler = "Marshaler"
+
+const (
+	// MarshalerGob encodes the whole message with Go's gob, readable only by Go consumers
+	MarshalerGob = "gob"
+	// MarshalerHeaders sends the payload as is with metadata in NATS headers (see HeaderMarshaler)
+	MarshalerHeaders = "headers"
+)
+
+// MarshalerType returns the marshaler selected in config, or fallback if none is set
+func MarshalerType(config ewm.WatermillConfig, fallback string) (string, error) {
+	value := config.OptionalValue(OptionMarshaler)
+
+	if value == "" {
+		return fallback, nil
+	}
+
+	switch marshaler := strings.ToLower(value); marshaler {
+	case MarshalerGob, MarshalerHeaders:
+		return marshaler, nil
+	default:
+		return "", fmt.Errorf("invalid value for %s: %s (must be gob or headers)", OptionMarshaler, value)
+	}
+}
+
+// UUIDHeader is the NATS header carrying the watermill message UUID
+const UUIDHeader = "_watermill_message_uuid"
+
+// HeaderMarshaler sends the message payload as is, carrying the UUID and metadata in NATS
+// headers so messages can be read by consumers not written in Go.  Headers need a NATS 2.2
+// server and are not available through NATS Streaming.
+type HeaderMarshaler struct{}
+
+func (HeaderMarshaler) Marshal(topic string, msg *message.Message) (*nats.Msg, error) {
+	natsMsg := nats.NewMsg(topic)
+
+	natsMsg.Data = msg.Payload
+	natsMsg.Header.Set(UUIDHeader, msg.UUID)
+
+	for k, v := range msg.Metadata {
+		if k != UUIDHeader {
+			natsMsg.Header.Set(k, v)
+		}
+	}
+
+	return natsMsg, nil
+}
+
+func (HeaderMarshaler) Unmarshal(natsMsg *nats.Msg) (*message.Message, error) {
+	id := natsMsg.Header.Get(UUIDHeader)
+
+	if id == "" {
+		id = watermill.NewUUID()
+	}
+
+	msg := message.NewMessage(id, natsMsg.Data)
+
+	for k := range natsMsg.Header {
+		if k != UUIDHeader {
+			msg.Metadata.Set(k, natsMsg.Header.Get(k))
+		}
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,243 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package natscommon
+
+import (
+	"encoding/pem"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nkeys"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testSecretProvider map[string]map[string]string
+
+func (tsp testSecretProvider) GetSecret(path string, keys ...string) (map[string]string, error) {
+	secrets, found := tsp[path]
+
+	if !found {
+		return nil, fmt.Errorf("no secrets at %s", path)
+	}
+
+	return secrets, nil
+}
+
+// withSecrets makes secrets available at a new path, returning config reading from it
+func withSecrets(t *testing.T, secrets map[string]string, optional map[string]string) ewm.WatermillConfig {
+	path := uuid.NewString()
+
+	ewm.SetSecretProvider(testSecretProvider{path: secrets})
+	t.Cleanup(func() { ewm.SetSecretProvider(nil) })
+
+	config := ewm.WatermillConfig{Optional: map[string]string{OptionSecretPath: path}}
+
+	for k, v := range optional {
+		config.Optional[k] = v
+	}
+
+	return config
+}
+
+// applyOptions returns the connection options that would be used by nats.Connect
+func applyOptions(t *testing.T, config ewm.WatermillConfig) nats.Options {
+	opts, err := AuthOptions(config)
+
+	require.NoError(t, err)
+
+	options := nats.GetDefaultOptions()
+
+	for _, opt := range opts {
+		require.NoError(t, opt(&options))
+	}
+
+	return options
+}
+
+func testSeed(t *testing.T) []byte {
+	kp, err := nkeys.CreateUser()
+	require.NoError(t, err)
+
+	seed, err := kp.Seed()
+	require.NoError(t, err)
+
+	return seed
+}
+
+func TestAuthOptions_None(t *testing.T) {
+	opts, err := AuthOptions(ewm.WatermillConfig{})
+
+	require.NoError(t, err)
+	require.Empty(t, opts)
+}
+
+func TestAuthOptions_UserInfo(t *testing.T) {
+	options := applyOptions(t, withSecrets(t, map[string]string{SecretUsername: "user", SecretPassword: "pass"}, nil))
+
+	require.Equal(t, "user", options.User)
+	require.Equal(t, "pass", options.Password)
+	require.False(t, options.Secure)
+}
+
+func TestAuthOptions_Token(t *testing.T) {
+	options := applyOptions(t, withSecrets(t, map[string]string{SecretToken: "token"}, nil))
+
+	require.Equal(t, "token", options.Token)
+}
+
+func TestAuthOptions_NKeySeed(t *testing.T) {
+	options := applyOptions(t, withSecrets(t, map[string]string{SecretNKeySeed: string(testSeed(t))}, nil))
+
+	require.NotEmpty(t, options.Nkey)
+	require.NotNil(t, options.SignatureCB)
+}
+
+func TestAuthOptions_Credentials(t *testing.T) {
+	creds := fmt.Sprintf("-----BEGIN NATS USER JWT-----\n%s\n------END NATS USER JWT------\n\n-----BEGIN USER NKEY SEED-----\n%s\n------END USER NKEY SEED------\n", "eyJhbGciOiJlZDI1NTE5In0.e30.sig", testSeed(t))
+
+	options := applyOptions(t, withSecrets(t, map[string]string{SecretCredentials: creds}, nil))
+
+	require.NotNil(t, options.UserJWT)
+	require.NotNil(t, options.SignatureCB)
+
+	jwt, err := options.UserJWT()
+
+	require.NoError(t, err)
+	require.Equal(t, "eyJhbGciOiJlZDI1NTE5In0.e30.sig", jwt)
+
+	signed, err := options.SignatureCB([]byte("nonce"))
+
+	require.NoError(t, err)
+	require.NotEmpty(t, signed)
+}
+
+func TestAuthOptions_TLS(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+
+	caCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	options := applyOptions(t, withSecrets(t, map[string]string{SecretCACert: caCert, SecretToken: "token"}, nil))
+
+	require.True(t, options.Secure)
+	require.NotNil(t, options.TLSConfig.RootCAs)
+	require.Equal(t, "token", options.Token, "TLS should combine with authentication")
+
+	options = applyOptions(t, ewm.WatermillConfig{Optional: map[string]string{OptionTLS: "true"}})
+
+	require.True(t, options.Secure)
+	require.Nil(t, options.TLSConfig.RootCAs, "should use system roots")
+}
+
+func TestAuthOptions_Invalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		secrets  map[string]string
+		optional map[string]string
+	}{
+		{"missing password", map[string]string{SecretUsername: "user"}, nil},
+		{"multiple methods", map[string]string{SecretUsername: "user", SecretPassword: "pass", SecretToken: "token"}, nil},
+		{"credentials file and secret", map[string]string{SecretToken: "token"}, map[string]string{OptionCredentialsFile: "/etc/user.creds"}},
+		{"bad seed", map[string]string{SecretNKeySeed: "not a seed"}, nil},
+		{"bad credentials", map[string]string{SecretCredentials: "not creds"}, nil},
+		{"bad ca", map[string]string{SecretCACert: "not a cert"}, nil},
+		{"bad tls flag", nil, map[string]string{OptionTLS: "maybe"}},
+		{"missing seed file", nil, map[string]string{OptionNKeySeedFile: "/" + uuid.NewString()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := AuthOptions(withSecrets(t, tt.secrets, tt.optional))
+
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestAuthOptions_NoSecretProvider(t *testing.T) {
+	_, err := AuthOptions(ewm.WatermillConfig{Optional: map[string]string{OptionSecretPath: uuid.NewString()}})
+
+	require.Error(t, err)
+}
+
+func TestConnectOptions_Invalid(t *testing.T) {
+	invalid := []map[string]string{
+		{OptionConnectTimeout: "5"},
+		{OptionReconnectWait: "soon"},
+		{OptionMaxReconnects: "lots"},
+	}
+
+	for _, optional := range invalid {
+		_, err := ConnectOptions(ewm.WatermillConfig{Optional: optional})
+		require.Error(t, err, optional)
+	}
+
+	opts, err := ConnectOptions(ewm.WatermillConfig{Optional: map[string]string{OptionMaxReconnects: "-1"}})
+
+	require.NoError(t, err)
+	require.Len(t, opts, 3)
+}
+func TestHeaderMarshaler(t *testing.T) {
+	msg := message.NewMessage(uuid.NewString(), []byte(`{"id":"1"}`))
+	msg.Metadata.Set("contentType", "application/json")
+	msg.Metadata.Set(UUIDHeader, "ignored")
+
+	natsMsg, err := HeaderMarshaler{}.Marshal("topic", msg)
+
+	require.NoError(t, err)
+	require.Equal(t, "topic", natsMsg.Subject)
+	require.Equal(t, []byte(msg.Payload), natsMsg.Data)
+	require.Equal(t, msg.UUID, natsMsg.Header.Get(UUIDHeader))
+	require.Equal(t, "application/json", natsMsg.Header.Get("contentType"), "metadata keys should keep their case")
+
+	received, err := HeaderMarshaler{}.Unmarshal(natsMsg)
+
+	require.NoError(t, err)
+	require.Equal(t, msg.UUID, received.UUID)
+	require.Equal(t, msg.Payload, received.Payload)
+	require.Equal(t, message.Metadata{"contentType": "application/json"}, received.Metadata)
+}
+
+func TestHeaderMarshaler_Unmarshal_NoHeaders(t *testing.T) {
+	received, err := HeaderMarshaler{}.Unmarshal(&nats.Msg{Subject: "topic", Data: []byte("payload")})
+
+	require.NoError(t, err)
+	require.NotEmpty(t, received.UUID)
+	require.Equal(t, []byte("payload"), []byte(received.Payload))
+	require.Empty(t, received.Metadata)
+}
+
+func TestMarshalerType(t *testing.T) {
+	marshaler, err := MarshalerType(ewm.WatermillConfig{}, MarshalerGob)
+
+	require.NoError(t, err)
+	require.Equal(t, MarshalerGob, marshaler)
+
+	marshaler, err = MarshalerType(ewm.WatermillConfig{Optional: map[string]string{"marshaler": "Headers"}}, MarshalerGob)
+
+	require.NoError(t, err)
+	require.Equal(t, MarshalerHeaders, marshaler)
+
+	_, err = MarshalerType(ewm.WatermillConfig{Optional: map[string]string{OptionMarshaler: "json"}}, MarshalerGob)
+
+	require.Error(t, err)
+}
@@ -0,0 +1,161 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package natscore
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill/message"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/alexcuse/edgex-watermill/v2/natscommon"
+	"github.com/edgexfoundry/app-functions-sdk-go/v2/pkg/interfaces"
+	"github.com/edgexfoundry/go-mod-core-contracts/v2/clients/logger"
+	"github.com/edgexfoundry/go-mod-messaging/v2/messaging"
+)
+
+const backendName = "natscore"
+
+// Optional settings read from WatermillConfig.Optional
+const (
+	OptionQueueGroup = "QueueGroup"
+)
+
+func init() {
+	err := ewm.RegisterBackend(backendName, ewm.Backend{
+		Publisher:  Publisher,
+		Subscriber: Subscriber,
+		Trigger:    Trigger,
+		Client:     Client,
+		Sender:     Sender,
+	})
+
+	if err != nil {
+		panic(err)
+	}
+}
+
+func Sender(config ewm.WatermillConfig, proceed bool, lc logger.LoggingClient) (ewm.WatermillSender, error) {
+	pub, err := Publisher(config, lc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ewm.NewWatermillSenderWithLogger(
+		pub,
+		proceed,
+		&config,
+		lc,
+	)
+}
+
+func Client(ctx context.Context, config ewm.WatermillConfig, lc logger.LoggingClient) (messaging.MessageClient, error) {
+	format := ewm.ResolveWireFormat(config.WireFormat, lc)
+
+	pub, err := Publisher(config, lc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := Subscriber(config, lc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ewm.NewWatermillClientWithLogger(
+		ctx,
+		pub,
+		sub,
+		format,
+		&config,
+		lc,
+	)
+}
+
+func Publisher(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Publisher, error) {
+	if err := checkMarshaler(config); err != nil {
+		return nil, err
+	}
+
+	conn, err := natscommon.Connect(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newPublisher(conn, natscommon.HeaderMarshaler{}), nil
+}
+
+func Subscriber(config ewm.WatermillConfig, lc logger.LoggingClient) (message.Subscriber, error) {
+	if err := checkMarshaler(config); err != nil {
+		return nil, err
+	}
+
+	conn, err := natscommon.Connect(config)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newSubscriber(
+		conn,
+		config.OptionalValue(OptionQueueGroup),
+		natscommon.HeaderMarshaler{},
+		ewm.NewBackendLogAdapter(lc, backendName),
+	), nil
+}
+
+// checkMarshaler rejects marshalers other than headers, which core NATS always uses
+func checkMarshaler(config ewm.WatermillConfig) error {
+	marshaler, err := natscommon.MarshalerType(config, natscommon.MarshalerHeaders)
+
+	if err != nil {
+		return err
+	}
+
+	if marshaler != natscommon.MarshalerHeaders {
+		return fmt.Errorf("the %s backend does not support the %s marshaler", backendName, marshaler)
+	}
+
+	return nil
+}
+
+func Trigger(wc *ewm.WatermillConfigWrapper, cfg interfaces.TriggerConfig) (interfaces.Trigger, error) {
+	format := ewm.ResolveWireFormat(wc.WatermillTrigger.WireFormat, cfg.Logger)
+
+	pub, err := Publisher(wc.WatermillTrigger, cfg.Logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := Subscriber(wc.WatermillTrigger, cfg.Logger)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ewm.NewWatermillTrigger(
+		pub,
+		sub,
+		format,
+		wc,
+		cfg,
+	)
+}
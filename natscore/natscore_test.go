@@ -0,0 +1,134 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package natscore
+
+import (
+	"context"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	ewm "github.com/alexcuse/edgex-watermill/v2/core"
+	"github.com/alexcuse/edgex-watermill/v2/natscommon"
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func testSubscriber() (*subscriber, *subscription) {
+	s := newSubscriber(nil, "", natscommon.HeaderMarshaler{}, watermill.NopLogger{})
+	return s, &subscription{output: make(chan *message.Message)}
+}
+
+func testNatsMsg(t *testing.T) (*message.Message, *nats.Msg) {
+	msg := message.NewMessage(uuid.NewString(), []byte("payload"))
+	msg.Metadata.Set("key", "value")
+
+	natsMsg, err := natscommon.HeaderMarshaler{}.Marshal("topic", msg)
+	require.NoError(t, err)
+
+	return msg, natsMsg
+}
+
+func TestBackendRegistered(t *testing.T) {
+	_, err := ewm.LookupBackend(backendName)
+
+	require.NoError(t, err)
+}
+
+func TestHandle_Ack(t *testing.T) {
+	s, sub := testSubscriber()
+	sent, natsMsg := testNatsMsg(t)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.handle(context.Background(), natsMsg, sub, watermill.NopLogger{})
+	}()
+
+	received := <-sub.output
+
+	require.Equal(t, sent.UUID, received.UUID)
+	require.Equal(t, sent.Payload, received.Payload)
+	require.Equal(t, "value", received.Metadata.Get("key"))
+
+	select {
+	case <-done:
+		t.Fatal("handler should wait for the message to be acknowledged")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	received.Ack()
+
+	<-done
+}
+
+func TestHandle_Nack(t *testing.T) {
+	s, sub := testSubscriber()
+	_, natsMsg := testNatsMsg(t)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		s.handle(context.Background(), natsMsg, sub, watermill.NopLogger{})
+	}()
+
+	(<-sub.output).Nack()
+
+	<-done
+}
+
+func TestHandle_ContextDone(t *testing.T) {
+	s, sub := testSubscriber()
+	_, natsMsg := testNatsMsg(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.handle(ctx, natsMsg, sub, watermill.NopLogger{})
+}
+
+func TestHandle_SubscriptionClosed(t *testing.T) {
+	s, sub := testSubscriber()
+	_, natsMsg := testNatsMsg(t)
+
+	sub.closed = true
+
+	s.handle(context.Background(), natsMsg, sub, watermill.NopLogger{})
+}
+
+func TestPublisher_ConnectFails(t *testing.T) {
+	_, err := Publisher(ewm.WatermillConfig{BrokerUrl: "nats://127.0.0.1:1"}, nil)
+
+	require.Error(t, err)
+}
+
+func TestSubscriber_InvalidOption(t *testing.T) {
+	_, err := Subscriber(ewm.WatermillConfig{Optional: map[string]string{natscommon.OptionConnectTimeout: "soon"}}, nil)
+
+	require.Error(t, err)
+}
+
+func TestGobMarshaler(t *testing.T) {
+	config := ewm.WatermillConfig{Optional: map[string]string{natscommon.OptionMarshaler: natscommon.MarshalerGob}}
+
+	_, err := Publisher(config, nil)
+	require.Error(t, err)
+
+	_, err = Subscriber(config, nil)
+	require.Error(t, err)
+}
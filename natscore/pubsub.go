@@ -0,0 +1,204 @@
+//
+// Copyright (c) 2021 Alex Ullrich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+//
+
+package natscore
+
+import (
+	"context"
+	"fmt"
+	"github.com/ThreeDotsLabs/watermill"
+	"github.com/ThreeDotsLabs/watermill/message"
+	"github.com/nats-io/nats.go"
+	"sync"
+)
+
+type marshaler interface {
+	Marshal(topic string, msg *message.Message) (*nats.Msg, error)
+}
+
+type unmarshaler interface {
+	Unmarshal(natsMsg *nats.Msg) (*message.Message, error)
+}
+
+// publisher publishes to core NATS subjects, which are not persisted so only reach
+// subscribers connected at the time
+type publisher struct {
+	conn      *nats.Conn
+	marshaler marshaler
+	closeOnce sync.Once
+}
+
+func newPublisher(conn *nats.Conn, marshaler marshaler) *publisher {
+	return &publisher{
+		conn:      conn,
+		marshaler: marshaler,
+	}
+}
+
+func (p *publisher) Publish(topic string, messages ...*message.Message) error {
+	for _, msg := range messages {
+		natsMsg, err := p.marshaler.Marshal(topic, msg)
+
+		if err != nil {
+			return fmt.Errorf("cannot marshal message %s: %s", msg.UUID, err.Error())
+		}
+
+		if err = p.conn.PublishMsg(natsMsg); err != nil {
+			return fmt.Errorf("cannot publish message %s to %s: %s", msg.UUID, topic, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (p *publisher) Close() error {
+	var err error
+
+	p.closeOnce.Do(func() {
+		err = p.conn.Flush()
+		p.conn.Close()
+	})
+
+	return err
+}
+
+// subscriber delivers core NATS messages one at a time per subscription, waiting for each to be
+// acknowledged before taking the next.  Subscriptions in the same queue group share the messages
+// published to a subject.  Core NATS cannot redeliver, so nacked messages are dropped.
+type subscriber struct {
+	conn        *nats.Conn
+	queueGroup  string
+	unmarshaler unmarshaler
+	logger      watermill.LoggerAdapter
+	closing     chan struct{}
+	closeOnce   sync.Once
+	subs        sync.WaitGroup
+}
+
+func newSubscriber(conn *nats.Conn, queueGroup string, unmarshaler unmarshaler, logger watermill.LoggerAdapter) *subscriber {
+	return &subscriber{
+		conn:        conn,
+		queueGroup:  queueGroup,
+		unmarshaler: unmarshaler,
+		logger:      logger,
+		closing:     make(chan struct{}),
+	}
+}
+
+// subscription guards the output channel so it is not closed while a handler is sending on it
+type subscription struct {
+	output chan *message.Message
+	mutex  sync.RWMutex
+	closed bool
+}
+
+func (s *subscriber) Subscribe(ctx context.Context, topic string) (<-chan *message.Message, error) {
+	select {
+	case <-s.closing:
+		return nil, fmt.Errorf("subscriber closed")
+	default:
+	}
+
+	logger := s.logger.With(watermill.LogFields{"topic": topic, "queue_group": s.queueGroup})
+	subscription := &subscription{output: make(chan *message.Message)}
+
+	handler := func(natsMsg *nats.Msg) {
+		s.handle(ctx, natsMsg, subscription, logger)
+	}
+
+	var sub *nats.Subscription
+	var err error
+
+	if s.queueGroup != "" {
+		sub, err = s.conn.QueueSubscribe(topic, s.queueGroup, handler)
+	} else {
+		sub, err = s.conn.Subscribe(topic, handler)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("cannot subscribe to %s: %s", topic, err.Error())
+	}
+
+	s.subs.Add(1)
+
+	go func() {
+		defer s.subs.Done()
+
+		select {
+		case <-ctx.Done():
+		case <-s.closing:
+		}
+
+		if err := sub.Unsubscribe(); err != nil && err != nats.ErrConnectionClosed {
+			logger.Error("Cannot unsubscribe", err, nil)
+		}
+
+		subscription.mutex.Lock()
+		defer subscription.mutex.Unlock()
+
+		subscription.closed = true
+		close(subscription.output)
+	}()
+
+	return subscription.output, nil
+}
+
+func (s *subscriber) handle(ctx context.Context, natsMsg *nats.Msg, subscription *subscription, logger watermill.LoggerAdapter) {
+	subscription.mutex.RLock()
+	defer subscription.mutex.RUnlock()
+
+	if subscription.closed {
+		return
+	}
+
+	msg, err := s.unmarshaler.Unmarshal(natsMsg)
+
+	if err != nil {
+		logger.Error("Cannot unmarshal message", err, nil)
+		return
+	}
+
+	msgCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	msg.SetContext(msgCtx)
+
+	select {
+	case subscription.output <- msg:
+	case <-ctx.Done():
+		return
+	case <-s.closing:
+		return
+	}
+
+	select {
+	case <-msg.Acked():
+	case <-msg.Nacked():
+		logger.Info("Message nacked, core NATS cannot redeliver it", watermill.LogFields{"message_uuid": msg.UUID})
+	case <-ctx.Done():
+	case <-s.closing:
+	}
+}
+
+func (s *subscriber) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+		s.subs.Wait()
+		s.conn.Close()
+	})
+
+	return nil
+}